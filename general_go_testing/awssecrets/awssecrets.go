@@ -0,0 +1,71 @@
+// Package awssecrets implements the SecretResolver interface of Memphis functions with AWS Systems Manager parameters
+// and Secrets Manager secrets, for WithSecretResolver to resolve the inputs referring to them.
+package awssecrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// SSM is the subset of an SSM client needed to resolve secrets, the AWS SDK *ssm.Client satisfies it.
+type SSM interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// SecretsManager is the subset of a Secrets Manager client needed to resolve secrets, the AWS SDK *secretsmanager.Client satisfies it.
+type SecretsManager interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// Parameters resolves secrets stored as SSM parameters, e.g. SecureString ones.
+type Parameters struct {
+	ssm SSM
+}
+
+// NewParameters returns a Parameters resolving secrets with ssm, e.g. for WithSecretResolver("ssm", NewParameters(ssm.NewFromConfig(cfg)), ttl)
+// to resolve the inputs like "ssm:/myapp/api-key".
+func NewParameters(ssm SSM) *Parameters {
+	return &Parameters{ssm: ssm}
+}
+
+// Resolve returns the value of the parameter name, decrypted if it is a SecureString.
+func (p *Parameters) Resolve(ctx context.Context, name string) (string, error) {
+	out, err := p.ssm.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(name), WithDecryption: aws.Bool(true)})
+	if err != nil {
+		return "", fmt.Errorf("couldn't get parameter %s: %w", name, err)
+	}
+	if out.Parameter == nil || out.Parameter.Value == nil {
+		return "", fmt.Errorf("parameter %s has no value", name)
+	}
+	return *out.Parameter.Value, nil
+}
+
+// Secrets resolves secrets stored in Secrets Manager.
+type Secrets struct {
+	secretsManager SecretsManager
+}
+
+// NewSecrets returns a Secrets resolving secrets with secretsManager,
+// e.g. for WithSecretResolver("secretsmanager", NewSecrets(secretsmanager.NewFromConfig(cfg)), ttl)
+// to resolve the inputs like "secretsmanager:prod/api-key".
+func NewSecrets(secretsManager SecretsManager) *Secrets {
+	return &Secrets{secretsManager: secretsManager}
+}
+
+// Resolve returns the SecretString of the current version of the secret name, its name or ARN.
+// Binary secrets aren't supported.
+func (s *Secrets) Resolve(ctx context.Context, name string) (string, error) {
+	out, err := s.secretsManager.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(name)})
+	if err != nil {
+		return "", fmt.Errorf("couldn't get secret %s: %w", name, err)
+	}
+	if out.SecretString == nil {
+		return "", errors.New("secret " + name + " has no SecretString, binary secrets aren't supported")
+	}
+	return *out.SecretString, nil
+}
@@ -0,0 +1,95 @@
+package awssecrets_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+
+	"go_template/awssecrets"
+)
+
+// the AWS SDK clients are usable as they are
+var (
+	_ awssecrets.SSM            = (*ssm.Client)(nil)
+	_ awssecrets.SecretsManager = (*secretsmanager.Client)(nil)
+)
+
+type fakeSSM struct {
+	parameters map[string]string
+	input      *ssm.GetParameterInput
+}
+
+func (f *fakeSSM) GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	f.input = params
+	value, ok := f.parameters[aws.ToString(params.Name)]
+	if !ok {
+		return nil, errors.New("ParameterNotFound")
+	}
+	return &ssm.GetParameterOutput{Parameter: &types.Parameter{Name: params.Name, Value: aws.String(value)}}, nil
+}
+
+func TestParameters(t *testing.T) {
+	client := &fakeSSM{parameters: map[string]string{"/app/api-key": "secret"}}
+	parameters := awssecrets.NewParameters(client)
+
+	value, err := parameters.Resolve(context.Background(), "/app/api-key")
+	if err != nil || value != "secret" {
+		t.Fatalf("Resolve = %q, %v, want the parameter value", value, err)
+	}
+	if !aws.ToBool(client.input.WithDecryption) {
+		t.Fatal("the parameter was fetched without decryption")
+	}
+
+	if _, err := parameters.Resolve(context.Background(), "/app/missing"); err == nil || !strings.Contains(err.Error(), "/app/missing") {
+		t.Fatalf("Resolve of a missing parameter returned %v, want an error naming it", err)
+	}
+}
+
+type fakeSecretsManager struct {
+	secrets map[string]*secretsmanager.GetSecretValueOutput
+}
+
+func (f fakeSecretsManager) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	out, ok := f.secrets[aws.ToString(params.SecretId)]
+	if !ok {
+		return nil, errors.New("ResourceNotFoundException")
+	}
+	return out, nil
+}
+
+func TestSecrets(t *testing.T) {
+	secrets := awssecrets.NewSecrets(fakeSecretsManager{secrets: map[string]*secretsmanager.GetSecretValueOutput{
+		"prod/api-key": {SecretString: aws.String("secret")},
+		"prod/binary":  {SecretBinary: []byte("secret")},
+	}})
+	tests := []struct {
+		name      string
+		secret    string
+		want      string
+		wantError string
+	}{
+		{name: "string", secret: "prod/api-key", want: "secret"},
+		{name: "binary", secret: "prod/binary", wantError: "binary secrets aren't supported"},
+		{name: "missing", secret: "prod/missing", wantError: "couldn't get secret prod/missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := secrets.Resolve(context.Background(), tt.secret)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("Resolve returned %v, want an error with %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil || value != tt.want {
+				t.Fatalf("Resolve = %q, %v, want %q", value, err, tt.want)
+			}
+		})
+	}
+}
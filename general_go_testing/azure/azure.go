@@ -0,0 +1,102 @@
+// Package azure runs Memphis functions as Azure Functions custom handlers.
+package azure
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"go_template/functions"
+	"go_template/internal/httpjson"
+)
+
+// PortEnv is the environment variable of the port Azure Functions expects a custom handler to listen on.
+const PortEnv = "FUNCTIONS_CUSTOMHANDLER_PORT"
+
+// azureInvocation is the request Azure Functions sends a custom handler for every invocation,
+// Data holds the value of every input binding by name.
+type azureInvocation struct {
+	Data     map[string]json.RawMessage `json:"Data"`
+	Metadata map[string]json.RawMessage `json:"Metadata"`
+}
+
+// azureResponse is the response of a custom handler to an invocation, ReturnValue goes to the $return output binding.
+type azureResponse struct {
+	Outputs     map[string]any `json:"Outputs"`
+	Logs        []string       `json:"Logs"`
+	ReturnValue any            `json:"ReturnValue"`
+}
+
+// Serve runs the function as an Azure Functions custom handler, listening on the FUNCTIONS_CUSTOMHANDLER_PORT.
+// Every invocation is processed like functions.CreateFunction does with the MemphisEvent of its single input binding,
+// either a JSON object or a string holding one, and the MemphisOutput is returned to the $return binding.
+// A failed invocation responds with a 500 status and the error in the Logs. The server shuts down like functions.ServeHTTP.
+func Serve(eventHandler functions.HandlerType, options ...functions.PayloadOption) error {
+	port := os.Getenv(PortEnv)
+	if port == "" {
+		return fmt.Errorf("%s is not set, the function doesn't run as an Azure Functions custom handler", PortEnv)
+	}
+	function := functions.NewFunction(eventHandler, options...)
+	return function.Serve(&http.Server{Addr: net.JoinHostPort("", port), Handler: newHandler(function)})
+}
+
+// NewHandler returns the http.Handler Serve serves the invocations with, e.g. to mount it on another server.
+func NewHandler(eventHandler functions.HandlerType, options ...functions.PayloadOption) http.Handler {
+	return newHandler(functions.NewFunction(eventHandler, options...))
+}
+
+// newHandler serves the invocations of function, rejecting bodies larger than its MaxRequestBodySize.
+func newHandler(function *functions.Function) http.Handler {
+	maxBodySize := function.MaxRequestBodySize()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var invocation azureInvocation
+		if status, err := httpjson.DecodeRequestBody(w, r, maxBodySize, &invocation); err != nil {
+			httpjson.WriteJSON(w, status, azureResponse{Logs: []string{fmt.Sprintf("invalid invocation: %v", err)}})
+			return
+		}
+
+		event, err := invocation.event()
+		if err != nil {
+			httpjson.WriteJSON(w, http.StatusBadRequest, azureResponse{Logs: []string{fmt.Sprintf("invalid invocation: %v", err)}})
+			return
+		}
+
+		output, err := function.Invoke(r.Context(), event)
+		if err != nil {
+			httpjson.WriteJSON(w, http.StatusInternalServerError, azureResponse{Logs: []string{err.Error()}})
+			return
+		}
+		httpjson.WriteJSON(w, http.StatusOK, azureResponse{Outputs: map[string]any{}, Logs: []string{}, ReturnValue: output})
+	})
+}
+
+// event extracts the MemphisEvent of the single input binding of the invocation.
+func (invocation azureInvocation) event() (*functions.MemphisEvent, error) {
+	if len(invocation.Data) != 1 {
+		return nil, fmt.Errorf("expected a single input binding, got %d", len(invocation.Data))
+	}
+
+	var name string
+	var data json.RawMessage
+	for name, data = range invocation.Data {
+	}
+
+	// bindings such as queues pass the event as a JSON string rather than as an object
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		var encoded string
+		if err := json.Unmarshal(data, &encoded); err != nil {
+			return nil, fmt.Errorf("input binding %s: %w", name, err)
+		}
+		data = json.RawMessage(encoded)
+	}
+
+	var event functions.MemphisEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, fmt.Errorf("input binding %s isn't a MemphisEvent: %w", name, err)
+	}
+	return &event, nil
+}
@@ -0,0 +1,167 @@
+package azure_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"go_template/azure"
+	"go_template/functions"
+)
+
+type response struct {
+	Outputs     map[string]any           `json:"Outputs"`
+	Logs        []string                 `json:"Logs"`
+	ReturnValue *functions.MemphisOutput `json:"ReturnValue"`
+}
+
+func upper(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+	return []byte(strings.ToUpper(string(payload.([]byte)))), headers, nil
+}
+
+func post(t *testing.T, handler http.Handler, body string) (*http.Response, response) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	httpResp, err := http.Post(server.URL+"/memphis", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+	var resp response
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		t.Fatalf("couldn't decode the response: %v", err)
+	}
+	return httpResp, resp
+}
+
+func TestInvocation(t *testing.T) {
+	payload := base64.StdEncoding.EncodeToString([]byte("payload"))
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "object", data: `{"inputs":{},"messages":[{"headers":{"h":"v"},"payload":"` + payload + `"}]}`},
+		{name: "string", data: `"{\"inputs\":{},\"messages\":[{\"headers\":{\"h\":\"v\"},\"payload\":\"` + payload + `\"}]}"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpResp, resp := post(t, azure.NewHandler(upper), `{"Data":{"event":`+tt.data+`},"Metadata":{}}`)
+
+			if httpResp.StatusCode != http.StatusOK {
+				t.Fatalf("status %d, logs %v", httpResp.StatusCode, resp.Logs)
+			}
+			if resp.Outputs == nil || len(resp.Outputs) != 0 {
+				t.Fatalf("Outputs = %v, want an empty object", resp.Outputs)
+			}
+			if resp.ReturnValue == nil || len(resp.ReturnValue.Messages) != 1 {
+				t.Fatalf("ReturnValue = %+v, want a single emitted message", resp.ReturnValue)
+			}
+			msg := resp.ReturnValue.Messages[0]
+			emitted, _ := base64.StdEncoding.DecodeString(msg.Payload)
+			if string(emitted) != "PAYLOAD" || msg.Headers["h"] != "v" {
+				t.Fatalf("emitted %q with headers %v", emitted, msg.Headers)
+			}
+		})
+	}
+}
+
+func TestInvalidInvocation(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "no binding", body: `{"Data":{},"Metadata":{}}`, status: http.StatusBadRequest},
+		{name: "two bindings", body: `{"Data":{"a":{},"b":{}},"Metadata":{}}`, status: http.StatusBadRequest},
+		{name: "not an event", body: `{"Data":{"event":[1]},"Metadata":{}}`, status: http.StatusBadRequest},
+		{name: "malformed", body: `{"Data":`, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpResp, resp := post(t, azure.NewHandler(upper), tt.body)
+
+			if httpResp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", httpResp.StatusCode, tt.status)
+			}
+			if len(resp.Logs) != 1 || !strings.Contains(resp.Logs[0], "invalid invocation") {
+				t.Fatalf("Logs = %v, want the error", resp.Logs)
+			}
+		})
+	}
+}
+
+func TestRequestBodyTooLarge(t *testing.T) {
+	handler := azure.NewHandler(upper, functions.WithMaxRequestBodySize(16))
+	httpResp, _ := post(t, handler, `{"Data":{"event":{"inputs":{},"messages":[]}},"Metadata":{}}`)
+
+	if httpResp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want %d", httpResp.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestServe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+	t.Setenv(azure.PortEnv, port)
+
+	served := make(chan error, 1)
+	go func() { served <- azure.Serve(upper) }()
+
+	body := `{"Data":{"event":{"inputs":{},"messages":[{"headers":{},"payload":"` + base64.StdEncoding.EncodeToString([]byte("payload")) + `"}]}},"Metadata":{}}`
+	var httpResp *http.Response
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		httpResp, err = http.Post("http://127.0.0.1:"+port+"/memphis", "application/json", strings.NewReader(body))
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the server didn't start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer httpResp.Body.Close()
+	var resp response
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		t.Fatalf("couldn't decode the response: %v", err)
+	}
+	if httpResp.StatusCode != http.StatusOK || resp.ReturnValue == nil || len(resp.ReturnValue.Messages) != 1 {
+		t.Fatalf("status %d, response %+v", httpResp.StatusCode, resp)
+	}
+	if emitted, _ := base64.StdEncoding.DecodeString(resp.ReturnValue.Messages[0].Payload); string(emitted) != "PAYLOAD" {
+		t.Fatalf("emitted %q, want PAYLOAD", emitted)
+	}
+
+	// Serve shuts down on SIGINT like it would when the host stops the function
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("Serve returned %v after SIGINT", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return after SIGINT")
+	}
+}
+
+func TestServeWithoutPort(t *testing.T) {
+	t.Setenv(azure.PortEnv, "")
+	if err := azure.Serve(upper); err == nil || !strings.Contains(err.Error(), azure.PortEnv) {
+		t.Fatalf("Serve returned %v, want an error about %s", err, azure.PortEnv)
+	}
+}
@@ -0,0 +1,90 @@
+package functions
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Base64Variant is one of the base64 alphabets and paddings payloads may be encoded with.
+type Base64Variant int
+
+const (
+	StdBase64 Base64Variant = iota + 1
+	RawStdBase64
+	URLBase64
+	RawURLBase64
+)
+
+// defaultBase64Variants are tried in order to decode payloads unless WithBase64Variants is used.
+var defaultBase64Variants = []Base64Variant{StdBase64, RawStdBase64, URLBase64, RawURLBase64}
+
+func (variant Base64Variant) encoding() *base64.Encoding {
+	switch variant {
+	case StdBase64:
+		return base64.StdEncoding
+	case RawStdBase64:
+		return base64.RawStdEncoding
+	case URLBase64:
+		return base64.URLEncoding
+	case RawURLBase64:
+		return base64.RawURLEncoding
+	default:
+		return nil
+	}
+}
+
+func (variant Base64Variant) String() string {
+	switch variant {
+	case StdBase64:
+		return "std"
+	case RawStdBase64:
+		return "raw std"
+	case URLBase64:
+		return "url"
+	case RawURLBase64:
+		return "raw url"
+	default:
+		return fmt.Sprintf("Base64Variant(%d)", int(variant))
+	}
+}
+
+// WithBase64Variants sets the base64 variants tried in order to decode payloads,
+// by default std, raw std, url and raw url. Outputs are always encoded with the std variant.
+func WithBase64Variants(variants ...Base64Variant) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if len(variants) == 0 {
+			return fmt.Errorf("at least one base64 variant is needed")
+		}
+		for _, variant := range variants {
+			if variant.encoding() == nil {
+				return fmt.Errorf("unknown base64 variant %v", variant)
+			}
+		}
+		payloadOptions.Base64Variants = variants
+		return nil
+	}
+}
+
+// decodeBase64 decodes payload with the first of the base64 variants that succeeds.
+func (payloadOptions *PayloadOptions) decodeBase64(payload string) ([]byte, error) {
+	variants := payloadOptions.Base64Variants
+	if variants == nil {
+		variants = defaultBase64Variants
+	}
+
+	var firstErr error
+	tried := make([]string, 0, len(variants))
+	for _, variant := range variants {
+		decoded, err := variant.encoding().DecodeString(payload)
+		if err == nil {
+			return decoded, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		tried = append(tried, variant.String())
+	}
+
+	return nil, fmt.Errorf("%w (tried base64 variants: %s)", firstErr, strings.Join(tried, ", "))
+}
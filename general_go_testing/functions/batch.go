@@ -0,0 +1,134 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+)
+
+// DecodedMsg is a message of the event decoded according to the PayloadType, as batch handlers get it.
+type DecodedMsg struct {
+	Payload any
+	Headers map[string]string
+	// Index is the position of the message in the event.
+	Index int
+}
+
+// FailedMsg is a message failed by a batch handler, it goes into the dead-letter station like the message at Index in the event.
+type FailedMsg struct {
+	Index int
+	Err   error
+}
+
+// BatchHandlerType functions get all the decoded messages of an event at once and return the messages to emit and the failed ones.
+// The messages that couldn't be decoded are failed without being passed to the handler.
+// Returning an error fails the whole invocation, a panic fails every message the handler got.
+type BatchHandlerType func(context.Context, []DecodedMsg, map[string]string) ([]OutMsg, []FailedMsg, error)
+
+// BuildBatchHandler is BuildHandler for batch handlers.
+func BuildBatchHandler(eventHandler BatchHandlerType, options ...PayloadOption) func(context.Context, *MemphisEvent) (*MemphisOutput, error) {
+	return newBatchFunction(eventHandler, options).Invoke
+}
+
+func newBatchFunction(eventHandler BatchHandlerType, options []PayloadOption) *Function {
+	config, err := newPayloadOptions(nil, options)
+	if err != nil {
+		return invalidOptionsFunction(err)
+	}
+
+	invoke := func(ctx context.Context, event *MemphisEvent) (*MemphisOutput, error) {
+		params := config.forInvocation()
+		endInvocation, err := params.beginInvocation()
+		if err != nil {
+			return nil, err
+		}
+		defer endInvocation()
+
+		inputs, err := params.resolveSecrets(ctx, event.Inputs)
+		if err != nil {
+			return nil, err
+		}
+		event = &MemphisEvent{Inputs: inputs, Messages: event.Messages}
+
+		if ctx, err = params.withInputs(ctx, event.Inputs); err != nil {
+			return nil, err
+		}
+
+		if err := params.resolveProtoMessage(event.Inputs); err != nil {
+			return nil, err
+		}
+
+		if ctx, err = params.initialize(ctx, event.Inputs); err != nil {
+			return nil, err
+		}
+
+		var processedEvent MemphisOutput
+		decodedMsgs := make([]DecodedMsg, 0, len(event.Messages))
+		for i, msg := range event.Messages {
+			switch params.preFilter(msg) {
+			case Filter:
+				continue
+			case Passthrough:
+				processedEvent.Messages = append(processedEvent.Messages, msg)
+				continue
+			}
+			input, handlerInput, _, category, err := params.decodeMessage(ctx, msg)
+			if err != nil {
+				failedMessage := newMsgWithError(msg, category, err, params.DefaultErrorClassification)
+				processedEvent.FailedMessages = append(processedEvent.FailedMessages, *failedMessage)
+				params.notifyFailure(ctx, msg, failedMessage, err)
+				continue
+			}
+			decodedMsgs = append(decodedMsgs, DecodedMsg{Payload: handlerInput, Headers: copyHeaders(input.Headers), Index: i})
+		}
+
+		outMsgs, failedMsgs, err := runBatchHandler(ctx, eventHandler, decodedMsgs, event.Inputs)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, failedMsg := range failedMsgs {
+			if failedMsg.Index < 0 || failedMsg.Index >= len(event.Messages) {
+				return nil, fmt.Errorf("failed message index %d out of range of the %d messages of the event", failedMsg.Index, len(event.Messages))
+			}
+			if failedMsg.Err == nil {
+				failedMsg.Err = fmt.Errorf("batch handler reported message %d as failed without an error", failedMsg.Index)
+			}
+			failedMessage := newMsgWithError(event.Messages[failedMsg.Index], HandlerErrorCategory, failedMsg.Err, params.DefaultErrorClassification)
+			processedEvent.FailedMessages = append(processedEvent.FailedMessages, *failedMessage)
+			params.notifyFailure(ctx, event.Messages[failedMsg.Index], failedMessage, failedMsg.Err)
+		}
+
+		for _, outMsg := range outMsgs {
+			// outputs aren't tied to an input message, so a nil payload is emitted empty
+			outputMsg, _, category, err := params.encodeOutput(ctx, MemphisMsg{}, outMsg)
+			if err != nil {
+				msg := MemphisMsg{Headers: outMsg.Headers}
+				failedMessage := newMsgWithError(msg, category, err, params.DefaultErrorClassification)
+				processedEvent.FailedMessages = append(processedEvent.FailedMessages, *failedMessage)
+				params.notifyFailure(ctx, msg, failedMessage, err)
+				continue
+			}
+			processedEvent.Messages = append(processedEvent.Messages, outputMsg)
+		}
+		params.limitErrors(processedEvent.FailedMessages)
+
+		return &processedEvent, nil
+	}
+	return &Function{invoke: invoke, config: config}
+}
+
+// runBatchHandler calls the batch handler and turns a panic into the failure of every message it got,
+// like a panicking handler fails its message instead of the whole invocation.
+func runBatchHandler(ctx context.Context, eventHandler BatchHandlerType, decodedMsgs []DecodedMsg, inputs map[string]string) (outMsgs []OutMsg, failedMsgs []FailedMsg, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := fmt.Errorf("batch handler panicked: %v\n%s", r, panicStack())
+			outMsgs, failedMsgs, err = nil, make([]FailedMsg, len(decodedMsgs)), nil
+			for i, msg := range decodedMsgs {
+				failedMsgs[i] = FailedMsg{Index: msg.Index, Err: panicErr}
+			}
+		}
+	}()
+
+	return eventHandler(ctx, decodedMsgs, inputs)
+}
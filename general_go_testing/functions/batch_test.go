@@ -0,0 +1,57 @@
+package functions_test
+
+import (
+	"context"
+	"testing"
+
+	"go_template/functions"
+	"go_template/memphistest"
+)
+
+func TestBatchFailedWithoutError(t *testing.T) {
+	handler := functions.BuildBatchHandler(func(ctx context.Context, msgs []functions.DecodedMsg, inputs map[string]string) ([]functions.OutMsg, []functions.FailedMsg, error) {
+		return []functions.OutMsg{{Payload: msgs[0].Payload}}, []functions.FailedMsg{{Index: 1}}, nil
+	})
+	event := memphistest.NewEvent().
+		AddMessage([]byte("1"), nil).
+		AddMessage([]byte("2"), nil).
+		Build(t)
+
+	out, err := handler(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 1 {
+		t.Fatalf("got %d emitted messages, want 1", len(out.Messages))
+	}
+	memphistest.RequireFailed(t, out, 0, "batch handler reported message 1 as failed without an error")
+}
+
+func TestBatchHandlerPanic(t *testing.T) {
+	handler := functions.BuildBatchHandler(func(ctx context.Context, msgs []functions.DecodedMsg, inputs map[string]string) ([]functions.OutMsg, []functions.FailedMsg, error) {
+		panic("boom")
+	}, functions.WithPreFilter(func(headers map[string]string) functions.FilterDecision {
+		if headers["skip"] == "true" {
+			return functions.Passthrough
+		}
+		return functions.Keep
+	}))
+	event := memphistest.NewEvent().
+		AddMessage([]byte("1"), nil).
+		AddMessage([]byte("2"), map[string]string{"skip": "true"}).
+		AddMessage([]byte("3"), nil).
+		Build(t)
+
+	out, err := handler(context.Background(), event)
+	if err != nil {
+		t.Fatalf("the panic failed the invocation: %v", err)
+	}
+	if len(out.Messages) != 1 {
+		t.Fatalf("got %d emitted messages, want the passed through one", len(out.Messages))
+	}
+	if len(out.FailedMessages) != 2 {
+		t.Fatalf("got %d failed messages, want the 2 the handler got", len(out.FailedMessages))
+	}
+	memphistest.RequireFailed(t, out, 0, "batch handler panicked: boom")
+	memphistest.RequireFailed(t, out, 1, "batch handler panicked: boom")
+}
@@ -0,0 +1,62 @@
+package functions
+
+import (
+	"context"
+	"time"
+)
+
+// WithOnMessageFailed calls onFailed whenever a message goes into the dead-letter station, i.e. is added to the FailedMessages,
+// e.g. to page on some categories or track the failures elsewhere. It gets the original message and the error it failed with,
+// classified like the failed message, and isn't called for filtered messages nor for the failures dropped or passed through by WithOnFailure.
+// onFailed is called synchronously, a panic in it is recovered and logged without affecting the processing.
+func WithOnMessageFailed(onFailed func(ctx context.Context, msg MemphisMsg, err error)) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.OnMessageFailed = onFailed
+		return nil
+	}
+}
+
+// notifyFailure calls the WithOnMessageFailed callback for msg, which failed as failedMessage with err.
+func (payloadOptions *PayloadOptions) notifyFailure(ctx context.Context, msg MemphisMsg, failedMessage *MemphisMsgWithError, err error) {
+	if payloadOptions.OnMessageFailed == nil {
+		return
+	}
+	if Classification(err) == Unclassified && failedMessage.Classification != Unclassified {
+		err = &classifiedError{err: err, classification: failedMessage.Classification}
+	}
+
+	defer payloadOptions.recoverCallback(ctx, "OnMessageFailed")
+	payloadOptions.OnMessageFailed(ctx, msg, err)
+}
+
+// recoverCallback recovers a panic of the callback named name, it must be deferred.
+func (payloadOptions *PayloadOptions) recoverCallback(ctx context.Context, name string) {
+	if r := recover(); r != nil && payloadOptions.Logger != nil {
+		payloadOptions.Logger.ErrorContext(ctx, name+" callback panicked", "panic", r, "stack", panicStack())
+	}
+}
+
+// WithOnSuccess calls onSuccess for every message emitted by the function, i.e. added to the Messages of the output,
+// e.g. to count business metrics. It gets the original message, the emitted one and how long processing the original took,
+// once per emitted message when the handler returns a []OutMsg. It isn't called for filtered or failed messages, nor in dry runs,
+// nor by batch functions whose outputs aren't tied to an original message.
+// onSuccess is called synchronously, a panic in it is recovered and logged without affecting the processing.
+func WithOnSuccess(onSuccess func(ctx context.Context, in MemphisMsg, out MemphisMsg, d time.Duration)) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.OnSuccess = onSuccess
+		return nil
+	}
+}
+
+// notifySuccess calls the WithOnSuccess callback for every message emitted for msg.
+func (payloadOptions *PayloadOptions) notifySuccess(ctx context.Context, msg MemphisMsg, result messageResult) {
+	if payloadOptions.OnSuccess == nil {
+		return
+	}
+	for _, out := range result.messages {
+		func() {
+			defer payloadOptions.recoverCallback(ctx, "OnSuccess")
+			payloadOptions.OnSuccess(ctx, msg, out, result.duration)
+		}()
+	}
+}
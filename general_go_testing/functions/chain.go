@@ -0,0 +1,43 @@
+package functions
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Chain returns a handler that threads the payload and headers of a message through handlers in order,
+// each one getting what the previous one returned, and returns what the last one returns.
+// A nil payload or nil headers returned by a handler keep the ones it was called with, like for a single handler.
+// The chain stops at the first handler that returns an error, which is returned wrapped with the position of the handler,
+// or that filters the message with ErrFilterMessage or nil payload and headers, which filters it out of the station.
+// Only the last handler may return a []OutMsg.
+// In typed mode the first handler gets the UserObject and the next ones whatever the previous one returned,
+// so handlers chained with PayloadInfo share the UserObject type and should return the same type of value they got.
+func Chain(handlers ...HandlerType) HandlerType {
+	return func(message any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		var payload any // stays nil, emitting the original payload, until a handler returns one
+		for i, handler := range handlers {
+			modifiedPayload, modifiedHeaders, err := handler(message, headers, inputs)
+			if errors.Is(err, ErrFilterMessage) {
+				return nil, nil, err
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("chained handler %d: %w", i, err)
+			}
+			if modifiedPayload == nil && modifiedHeaders == nil {
+				return nil, nil, nil
+			}
+			if _, ok := modifiedPayload.([]OutMsg); ok && i < len(handlers)-1 {
+				return nil, nil, fmt.Errorf("chained handler %d: only the last handler can return a []OutMsg", i)
+			}
+
+			if modifiedPayload != nil {
+				message, payload = modifiedPayload, modifiedPayload
+			}
+			if modifiedHeaders != nil {
+				headers = modifiedHeaders
+			}
+		}
+		return payload, headers, nil
+	}
+}
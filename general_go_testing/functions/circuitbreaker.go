@@ -0,0 +1,55 @@
+package functions
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// errCircuitOpen fails the messages whose handler isn't called because the circuit breaker is open.
+var errCircuitOpen = errors.New("not processed: circuit open")
+
+// circuitBreaker counts the consecutive handler errors of an invocation.
+type circuitBreaker struct {
+	threshold int
+
+	mu          sync.Mutex
+	consecutive int
+}
+
+// WithCircuitBreaker stops calling the handler for the rest of the invocation once it returned consecutiveFailures errors in a row,
+// e.g. because a dependency is down, the remaining messages are returned as UnprocessedMessages without waiting for the handler.
+// The breaker closes again at the start of every invocation.
+func WithCircuitBreaker(consecutiveFailures int) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if consecutiveFailures <= 0 {
+			return fmt.Errorf("circuit breaker threshold must be positive, got %d", consecutiveFailures)
+		}
+		payloadOptions.CircuitBreaker = &circuitBreaker{threshold: consecutiveFailures}
+		return nil
+	}
+}
+
+// open tells whether the handler shouldn't be called anymore.
+func (breaker *circuitBreaker) open() bool {
+	if breaker == nil {
+		return false
+	}
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	return breaker.consecutive >= breaker.threshold
+}
+
+// record counts a handler call that failed or not.
+func (breaker *circuitBreaker) record(failed bool) {
+	if breaker == nil {
+		return
+	}
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if failed {
+		breaker.consecutive++
+	} else {
+		breaker.consecutive = 0
+	}
+}
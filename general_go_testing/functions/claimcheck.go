@@ -0,0 +1,97 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// PayloadLocationHeader tells where the actual payload of a claim-check message is, s3 being the only location supported.
+const PayloadLocationHeader = "x-payload-location"
+
+const s3PayloadLocation = "s3"
+
+// PayloadFetcher fetches and stores the payloads of claim-check messages, whose payload is a pointer to the actual one,
+// see the s3claimcheck package for an implementation backed by S3.
+type PayloadFetcher interface {
+	// Fetch returns the payload stored under key in bucket.
+	Fetch(ctx context.Context, bucket string, key string) ([]byte, error)
+	// Store stores payload and returns the bucket and key it is stored under.
+	Store(ctx context.Context, payload []byte) (bucket string, key string, err error)
+}
+
+// claimCheckPointer is the payload of claim-check messages.
+type claimCheckPointer struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+}
+
+// WithClaimCheck fetches the actual payload of the messages whose x-payload-location header is s3 with fetcher,
+// their payload being a {"bucket": ..., "key": ...} pointer. The handler gets the fetched payload and the headers without x-payload-location.
+// Messages whose payload can't be fetched are failed as retryable.
+func WithClaimCheck(fetcher PayloadFetcher) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.ClaimCheck = fetcher
+		return nil
+	}
+}
+
+// WithClaimCheckThreshold stores the outputs larger than size bytes with the WithClaimCheck fetcher,
+// and emits a pointer to them with the x-payload-location header instead. Zero never stores outputs.
+func WithClaimCheckThreshold(size int) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if size < 0 {
+			return fmt.Errorf("claim check threshold must not be negative, got %d", size)
+		}
+		payloadOptions.ClaimCheckThreshold = size
+		return nil
+	}
+}
+
+// fetchClaimCheck returns the payload pointed to by payload and the headers without the location header,
+// payloads of messages without the location header are returned as is.
+func (payloadOptions *PayloadOptions) fetchClaimCheck(ctx context.Context, payload []byte, headers map[string]string) ([]byte, map[string]string, error) {
+	if !Headers(headers).Has(PayloadLocationHeader) {
+		return payload, headers, nil
+	}
+	location := Headers(headers).Get(PayloadLocationHeader)
+	if location != s3PayloadLocation {
+		return nil, nil, fmt.Errorf("unsupported payload location %q", location)
+	}
+
+	var pointer claimCheckPointer
+	if err := json.Unmarshal(payload, &pointer); err != nil {
+		return nil, nil, fmt.Errorf("invalid claim check pointer: %w", err)
+	}
+	fetched, err := payloadOptions.ClaimCheck.Fetch(ctx, pointer.Bucket, pointer.Key)
+	if err != nil {
+		return nil, nil, Retryable(fmt.Errorf("couldn't fetch payload from %s/%s: %w", pointer.Bucket, pointer.Key, err))
+	}
+
+	return fetched, withoutHeader(headers, PayloadLocationHeader), nil
+}
+
+// storeClaimCheck stores payload when it is larger than the ClaimCheckThreshold and returns a pointer to it,
+// along with a copy of headers with the location header set. Otherwise payload and headers are returned as is.
+func (payloadOptions *PayloadOptions) storeClaimCheck(ctx context.Context, payload []byte, headers map[string]string) ([]byte, map[string]string, error) {
+	if payloadOptions.ClaimCheck == nil || payloadOptions.ClaimCheckThreshold == 0 || len(payload) <= payloadOptions.ClaimCheckThreshold {
+		return payload, headers, nil
+	}
+
+	bucket, key, err := payloadOptions.ClaimCheck.Store(ctx, payload)
+	if err != nil {
+		return nil, nil, Retryable(fmt.Errorf("couldn't store payload: %w", err))
+	}
+	pointer, err := json.Marshal(claimCheckPointer{Bucket: bucket, Key: key})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pointerHeaders := copyHeaders(headers)
+	if pointerHeaders == nil {
+		pointerHeaders = make(map[string]string, 1)
+	}
+	pointerHeaders[PayloadLocationHeader] = s3PayloadLocation
+
+	return pointer, pointerHeaders, nil
+}
@@ -0,0 +1,46 @@
+package functions_test
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"go_template/functions"
+	"go_template/memphistest"
+)
+
+type fakePayloadFetcher struct {
+	payloads map[string][]byte
+}
+
+func (f fakePayloadFetcher) Fetch(ctx context.Context, bucket string, key string) ([]byte, error) {
+	return f.payloads[bucket+"/"+key], nil
+}
+
+func (f fakePayloadFetcher) Store(ctx context.Context, payload []byte) (string, string, error) {
+	f.payloads["bucket/stored"] = payload
+	return "bucket", "stored", nil
+}
+
+func TestFetchClaimCheckIgnoresHeaderCasing(t *testing.T) {
+	fetcher := fakePayloadFetcher{payloads: map[string][]byte{"bucket/key": []byte("fetched")}}
+	handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		return payload, headers, nil
+	}, functions.WithClaimCheck(fetcher))
+	event := memphistest.NewEvent().AddMessage([]byte(`{"bucket":"bucket","key":"key"}`), map[string]string{"X-Payload-Location": "s3"}).Build(t)
+
+	out, err := handler(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 1 {
+		t.Fatalf("got %d emitted messages, failed: %+v", len(out.Messages), out.FailedMessages)
+	}
+	payload, _ := base64.StdEncoding.DecodeString(out.Messages[0].Payload)
+	if string(payload) != "fetched" {
+		t.Fatalf("emitted payload %q, want the fetched one", payload)
+	}
+	if functions.Headers(out.Messages[0].Headers).Has(functions.PayloadLocationHeader) {
+		t.Fatalf("emitted headers %v still have the payload location", out.Messages[0].Headers)
+	}
+}
@@ -0,0 +1,61 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+)
+
+// RunCLI processes a single MemphisEvent read as JSON from stdin and prints the MemphisOutput to stdout,
+// so a captured event can be piped through the function locally: cat event.json | ./myfunc --local
+// The process exits with status 1 if a message failed and 2 if the event couldn't be processed at all.
+// The --payload-raw flag reads the payloads of the event, and prints the ones of the output, as plain strings instead of base64,
+// like WithRawPayloadEncoding. The --local flag is accepted so the function's main can use it to choose RunCLI.
+func RunCLI(eventHandler HandlerType, options ...PayloadOption) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := runCLI(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr, eventHandler, options)
+	stop()
+	os.Exit(code)
+}
+
+func runCLI(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer, eventHandler HandlerType, options []PayloadOption) int {
+	flags := flag.NewFlagSet("memphis function", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Bool("local", false, "run the function locally, on the event read from stdin")
+	payloadRaw := flags.Bool("payload-raw", false, "read and print the payloads as plain strings instead of base64")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *payloadRaw {
+		options = append(options[:len(options):len(options)], WithRawPayloadEncoding())
+	}
+
+	var event MemphisEvent
+	if err := json.NewDecoder(stdin).Decode(&event); err != nil {
+		fmt.Fprintf(stderr, "invalid event: %v\n", err)
+		return 2
+	}
+
+	output, err := BuildHandler(eventHandler, options...)(ctx, &event)
+	if err != nil {
+		fmt.Fprintf(stderr, "invocation failed: %v\n", err)
+		return 2
+	}
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(output); err != nil {
+		fmt.Fprintf(stderr, "couldn't print the output: %v\n", err)
+		return 2
+	}
+
+	if len(output.FailedMessages) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,153 @@
+package functions
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ContentEncodingHeader is the header telling how the payload of a message is compressed.
+const ContentEncodingHeader = "content-encoding"
+
+// defaultMaxDecompressedSize caps decompressed payloads unless WithMaxDecompressedSize is used.
+const defaultMaxDecompressedSize = 64 << 20
+
+// WithCompression decompresses payloads whose content-encoding header is gzip or zstd before they are decoded,
+// the handler then gets the headers without the content-encoding header.
+func WithCompression() PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.Compression = true
+		return nil
+	}
+}
+
+// WithMaxDecompressedSize fails messages whose decompressed payload is larger than maxBytes, to defend against decompression bombs.
+func WithMaxDecompressedSize(maxBytes int64) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if maxBytes <= 0 {
+			return fmt.Errorf("max decompressed size must be positive, got %d", maxBytes)
+		}
+		payloadOptions.MaxDecompressedSize = maxBytes
+		return nil
+	}
+}
+
+// decompress decompresses payload according to its content-encoding header,
+// and returns the headers without it. Payloads without the header are returned as is.
+func (payloadOptions *PayloadOptions) decompress(payload []byte, headers map[string]string) ([]byte, map[string]string, error) {
+	if !Headers(headers).Has(ContentEncodingHeader) {
+		return payload, headers, nil
+	}
+
+	encoding := Headers(headers).Get(ContentEncodingHeader)
+	var reader io.Reader
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "identity":
+		reader = bytes.NewReader(payload)
+	case "gzip":
+		gzipReader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	case "zstd":
+		zstdReader, err := zstd.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, nil, err
+		}
+		defer zstdReader.Close()
+		reader = zstdReader
+	default:
+		return nil, nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+
+	maxSize := payloadOptions.MaxDecompressedSize
+	if maxSize == 0 {
+		maxSize = defaultMaxDecompressedSize
+	}
+	decompressed, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(decompressed)) > maxSize {
+		return nil, nil, fmt.Errorf("decompressed payload exceeds %d bytes", maxSize)
+	}
+
+	return decompressed, withoutHeader(headers, ContentEncodingHeader), nil
+}
+
+// withoutHeader returns a copy of headers without key in any casing, headers itself is left untouched.
+func withoutHeader(headers map[string]string, key string) map[string]string {
+	stripped := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if !strings.EqualFold(k, key) {
+			stripped[k] = v
+		}
+	}
+	return stripped
+}
+
+// WithOutputCompression compresses the marshaled payloads returned by the handler that are larger than minSize bytes,
+// using encoding which is either gzip or zstd, and sets the content-encoding header of the emitted message.
+// Payloads whose headers already have a content-encoding are emitted as is.
+func WithOutputCompression(encoding string, minSize int) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if encoding != "gzip" && encoding != "zstd" {
+			return fmt.Errorf("unsupported output content encoding %q", encoding)
+		}
+		if minSize < 0 {
+			return fmt.Errorf("output compression min size must not be negative, got %d", minSize)
+		}
+		payloadOptions.OutputCompression = encoding
+		payloadOptions.OutputCompressionMinSize = minSize
+		return nil
+	}
+}
+
+// compressOutput compresses payload when output compression is enabled and it is large enough,
+// returning a copy of headers with the content-encoding header set. Otherwise payload and headers are returned as is.
+func (payloadOptions *PayloadOptions) compressOutput(payload []byte, headers map[string]string) ([]byte, map[string]string, error) {
+	if payloadOptions.OutputCompression == "" || len(payload) <= payloadOptions.OutputCompressionMinSize {
+		return payload, headers, nil
+	}
+	if Headers(headers).Has(ContentEncodingHeader) {
+		return payload, headers, nil
+	}
+
+	var buf bytes.Buffer
+	switch payloadOptions.OutputCompression {
+	case "gzip":
+		gzipWriter := gzip.NewWriter(&buf)
+		if _, err := gzipWriter.Write(payload); err != nil {
+			return nil, nil, err
+		}
+		if err := gzipWriter.Close(); err != nil {
+			return nil, nil, err
+		}
+	case "zstd":
+		zstdWriter, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := zstdWriter.Write(payload); err != nil {
+			return nil, nil, err
+		}
+		if err := zstdWriter.Close(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	compressedHeaders := copyHeaders(headers)
+	if compressedHeaders == nil {
+		compressedHeaders = make(map[string]string, 1)
+	}
+	compressedHeaders[ContentEncodingHeader] = payloadOptions.OutputCompression
+
+	return buf.Bytes(), compressedHeaders, nil
+}
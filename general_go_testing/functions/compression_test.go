@@ -0,0 +1,60 @@
+package functions_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"go_template/functions"
+	"go_template/memphistest"
+)
+
+func gzipped(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	if _, err := gzipWriter.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompressIgnoresHeaderCasing(t *testing.T) {
+	handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		return payload, headers, nil
+	}, functions.WithCompression())
+	event := memphistest.NewEvent().AddMessage(gzipped(t, []byte("payload")), map[string]string{"Content-Encoding": "gzip"}).Build(t)
+
+	out, err := handler(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 1 {
+		t.Fatalf("got %d emitted messages, failed: %+v", len(out.Messages), out.FailedMessages)
+	}
+	payload, _ := base64.StdEncoding.DecodeString(out.Messages[0].Payload)
+	if string(payload) != "payload" {
+		t.Fatalf("emitted payload %q, want it decompressed", payload)
+	}
+	if functions.Headers(out.Messages[0].Headers).Has(functions.ContentEncodingHeader) {
+		t.Fatalf("emitted headers %v still have the content encoding", out.Messages[0].Headers)
+	}
+}
+
+func TestCompressOutputKeepsEncodedPayloads(t *testing.T) {
+	params := &functions.PayloadOptions{OutputCompression: "gzip"}
+	headers := map[string]string{"Content-Encoding": "identity"}
+
+	payload, compressedHeaders, err := params.CompressOutput([]byte("payload"), headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(payload) != "payload" || compressedHeaders["Content-Encoding"] != "identity" || len(compressedHeaders) != 1 {
+		t.Fatalf("compressOutput re-encoded a payload with a Content-Encoding header: %q, %v", payload, compressedHeaders)
+	}
+}
@@ -0,0 +1,106 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+type configContextKey struct{}
+
+// LoadConfig fills the struct out points to from the inputs of the invocation, the environment variables and defaults,
+// according to the tags of its fields, e.g. `cfg:"timeout" env:"HANDLER_TIMEOUT" default:"5s" required:"true"`.
+// The input named by cfg takes precedence over the environment variable named by env, which takes precedence over the default.
+// Fields can be strings, bools, integers, floats, time.Duration or []string, whose value is split on commas.
+// All the required fields left unset and the values that can't be parsed are reported in a single error.
+func LoadConfig(inputs map[string]string, out any) error {
+	outValue := reflect.ValueOf(out)
+	if outValue.Kind() != reflect.Pointer || outValue.IsNil() || outValue.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config must be a non-nil pointer to a struct, got %T", out)
+	}
+	structValue := outValue.Elem()
+	structType := structValue.Type()
+
+	var missing []string
+	var errs []error
+	for i := range structType.NumField() {
+		field := structType.Field(i)
+		inputName, fromInput := field.Tag.Lookup("cfg")
+		envName, fromEnv := field.Tag.Lookup("env")
+		if !field.IsExported() || (!fromInput && !fromEnv) {
+			continue
+		}
+
+		var value, source string
+		var ok bool
+		if fromInput {
+			value, ok = inputs[inputName]
+			source = "input " + inputName
+		}
+		if !ok && fromEnv {
+			value, ok = os.LookupEnv(envName)
+			source = "environment variable " + envName
+		}
+		if !ok {
+			value, ok = field.Tag.Lookup("default")
+			source = "default"
+		}
+		if !ok {
+			if field.Tag.Get("required") == "true" {
+				missing = append(missing, configFieldName(field))
+			}
+			continue
+		}
+
+		if err := setFieldFromString(structValue.Field(i), value); err != nil {
+			errs = append(errs, fmt.Errorf("couldn't decode config %s from %s: %w", configFieldName(field), source, err))
+		}
+	}
+
+	if len(missing) > 0 {
+		errs = append([]error{fmt.Errorf("missing required config: %s", strings.Join(missing, ", "))}, errs...)
+	}
+	return errors.Join(errs...)
+}
+
+// configFieldName names field in errors, after its input or environment variable.
+func configFieldName(field reflect.StructField) string {
+	if name := field.Tag.Get("cfg"); name != "" {
+		return name
+	}
+	return field.Tag.Get("env")
+}
+
+// WithConfig loads the config of every invocation into a fresh copy of schema, a pointer to a struct tagged as for LoadConfig,
+// failing the invocation before any message is processed if it can't be loaded. Handlers get it with TypedConfig.
+func WithConfig(schema any) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		schemaType := reflect.TypeOf(schema)
+		if schemaType == nil || schemaType.Kind() != reflect.Pointer || schemaType.Elem().Kind() != reflect.Struct {
+			return fmt.Errorf("config schema must be a pointer to a struct, got %v", schemaType)
+		}
+		payloadOptions.ConfigSchema = schema
+		return nil
+	}
+}
+
+// TypedConfig returns the config of the invocation loaded by WithConfig, ok is false when it wasn't loaded into a *T.
+func TypedConfig[T any](ctx context.Context) (config *T, ok bool) {
+	config, ok = ctx.Value(configContextKey{}).(*T)
+	return config, ok
+}
+
+// withConfig adds the config loaded from inputs to ctx if a WithConfig schema is set.
+func (payloadOptions *PayloadOptions) withConfig(ctx context.Context, inputs map[string]string) (context.Context, error) {
+	if payloadOptions.ConfigSchema == nil {
+		return ctx, nil
+	}
+	config := newUserObject(payloadOptions.ConfigSchema)
+	if err := LoadConfig(inputs, config); err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, configContextKey{}, config), nil
+}
@@ -0,0 +1,66 @@
+package functions
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// ContentTypeHeader is the header WithContentTypeRouting picks the PayloadType of a message by.
+const ContentTypeHeader = "content-type"
+
+// WithContentTypeRouting decodes every message according to the PayloadType its content-type header maps to in routes,
+// e.g. "application/json" to JSON, and marshals the outputs of the handler back to the same type.
+// Messages without the header use the PayloadType of PayloadInfo, those with an unknown content type are failed.
+// The user schema, if any, must suit all the routed types, and the default serializer of each type is used.
+func WithContentTypeRouting(routes map[string]PayloadTypes) PayloadOption {
+	normalized := make(map[string]PayloadTypes, len(routes))
+	for contentType, payloadType := range routes {
+		normalized[strings.ToLower(contentType)] = payloadType
+	}
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.ContentTypeRoutes = normalized
+		return nil
+	}
+}
+
+// routed returns the options to decode and encode a message with headers with,
+// according to its content-type header, or else to the format detected by WithFormatDetection.
+func (payloadOptions *PayloadOptions) routed(headers map[string]string) (*PayloadOptions, error) {
+	payloadType, ok, err := payloadOptions.routedPayloadType(headers)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || payloadType == payloadOptions.PayloadType {
+		return payloadOptions, nil
+	}
+
+	routedOptions := *payloadOptions
+	routedOptions.PayloadType = payloadType
+	routedOptions.Serializer = defaultSerializer(&routedOptions)
+	return &routedOptions, nil
+}
+
+func (payloadOptions *PayloadOptions) routedPayloadType(headers map[string]string) (PayloadTypes, bool, error) {
+	if contentType := Headers(headers).Get(ContentTypeHeader); payloadOptions.ContentTypeRoutes != nil && contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid content type %q: %w", contentType, err)
+		}
+		payloadType, ok := payloadOptions.ContentTypeRoutes[mediaType]
+		if !ok {
+			return 0, false, fmt.Errorf("unsupported content type %q", mediaType)
+		}
+		return payloadType, true, nil
+	}
+
+	if format, ok := Headers(headers).lookup(PayloadFormatHeader); payloadOptions.DetectFormats != nil && ok {
+		for _, payloadType := range payloadOptions.DetectFormats {
+			if payloadType.String() == format {
+				return payloadType, true, nil
+			}
+		}
+	}
+
+	return 0, false, nil
+}
@@ -0,0 +1,24 @@
+package functions
+
+import (
+	"context"
+)
+
+// Crypto encrypts and decrypts message payloads, e.g. with envelope encryption,
+// see the kmscrypto package for a reference implementation backed by KMS.
+type Crypto interface {
+	// Decrypt is called with the payload right after it is base64 decoded, along with the headers of the message.
+	Decrypt(ctx context.Context, payload []byte, headers map[string]string) ([]byte, error)
+	// Encrypt is called with the marshaled output right before it is base64 encoded,
+	// it returns the encrypted payload and the headers to emit, carrying the encryption metadata.
+	Encrypt(ctx context.Context, payload []byte, headers map[string]string) ([]byte, map[string]string, error)
+}
+
+// WithPayloadCrypto decrypts incoming payloads before they are decoded and encrypts the outputs of the handler before they are encoded.
+// Messages failing to decrypt are sent to the dead-letter station with the decrypt category.
+func WithPayloadCrypto(c Crypto) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.Crypto = c
+		return nil
+	}
+}
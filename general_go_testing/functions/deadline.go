@@ -0,0 +1,35 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WithDeadlineMargin stops processing the messages of an invocation once its deadline is less than margin away,
+// so the function returns the results of the messages already processed instead of timing out and losing them.
+// The remaining messages are returned as UnprocessedMessages so they are redelivered.
+func WithDeadlineMargin(margin time.Duration) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if margin <= 0 {
+			return fmt.Errorf("deadline margin must be positive, got %v", margin)
+		}
+		payloadOptions.DeadlineMargin = margin
+		return nil
+	}
+}
+
+// checkDeadline returns the retryable error of a message that can't be processed anymore because ctx is done,
+// e.g. canceled on SIGTERM, or its deadline is within the DeadlineMargin, nil when it can still be processed.
+func (payloadOptions *PayloadOptions) checkDeadline(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return Retryable(fmt.Errorf("not processed: %w", err))
+	}
+	if payloadOptions.DeadlineMargin == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < payloadOptions.DeadlineMargin {
+		return Retryable(errDeadlineNotProcessed)
+	}
+	return nil
+}
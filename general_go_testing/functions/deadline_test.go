@@ -0,0 +1,94 @@
+package functions_test
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go_template/functions"
+	"go_template/memphistest"
+)
+
+// fakeDeadlineContext is a context whose deadline the test moves, so the deadline is reached without waiting for it.
+type fakeDeadlineContext struct {
+	context.Context
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (ctx *fakeDeadlineContext) Deadline() (time.Time, bool) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.deadline, true
+}
+
+func (ctx *fakeDeadlineContext) setDeadline(deadline time.Time) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.deadline = deadline
+}
+
+func TestDeadlineMargin(t *testing.T) {
+	ctx := &fakeDeadlineContext{Context: context.Background(), deadline: time.Now().Add(time.Hour)}
+	var calls int
+	handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		calls++
+		if calls == 2 {
+			// the deadline is now within the margin, the following messages aren't processed
+			ctx.setDeadline(time.Now().Add(time.Second))
+		}
+		return payload, headers, nil
+	}, functions.WithDeadlineMargin(2*time.Second))
+	builder := memphistest.NewEvent()
+	for i := range 5 {
+		builder.AddMessage([]byte(strconv.Itoa(i)), nil)
+	}
+
+	out, err := handler(ctx, builder.Build(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("the handler was called %d times, want 2", calls)
+	}
+	if len(out.Messages) != 2 || len(out.FailedMessages) != 0 {
+		t.Fatalf("got %d emitted and %d failed messages, want the 2 processed ones emitted", len(out.Messages), len(out.FailedMessages))
+	}
+	if len(out.UnprocessedMessages) != 3 {
+		t.Fatalf("got %d unprocessed messages, want 3", len(out.UnprocessedMessages))
+	}
+	for i, msg := range out.UnprocessedMessages {
+		if payload, _ := base64.StdEncoding.DecodeString(msg.Payload); string(payload) != strconv.Itoa(i+2) {
+			t.Errorf("unprocessed message %d is %q, want %q", i, payload, strconv.Itoa(i+2))
+		}
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	var calls int
+	handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		calls++
+		return payload, headers, nil
+	})
+	event := memphistest.NewEvent().
+		AddMessage([]byte("1"), nil).
+		AddMessage([]byte("2"), nil).
+		Build(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	out, err := handler(ctx, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 0 {
+		t.Fatalf("the handler was called %d times, want 0", calls)
+	}
+	if len(out.Messages) != 0 || len(out.FailedMessages) != 0 || len(out.UnprocessedMessages) != 2 {
+		t.Fatalf("got %d emitted, %d failed and %d unprocessed messages, want all unprocessed",
+			len(out.Messages), len(out.FailedMessages), len(out.UnprocessedMessages))
+	}
+}
@@ -0,0 +1,97 @@
+package functions
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DebugSampleInput is the input setting how many messages of an invocation are sampled, like WithDebugSample, 0 turns sampling off.
+const DebugSampleInput = "__debug_sample"
+
+// debugSampleMaxBytes bounds the part of the payload of a sampled message that is logged.
+const debugSampleMaxBytes = 512
+
+const redactedHeader = "[REDACTED]"
+
+// WithDebugSample logs the first n messages of every invocation as they arrived, with their base64 payload,
+// a hex dump of their decoded payload and their headers, the values of the redactHeaders headers being redacted.
+// Payloads are truncated to 512 bytes. The messages are logged at Info level with the logger of WithLogger, or the default one.
+// The DebugSampleInput overrides n for an invocation, so sampling can be turned on for a station without redeploying.
+func WithDebugSample(n int, redactHeaders ...string) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if n < 0 {
+			return fmt.Errorf("debug sample size can't be negative, got %d", n)
+		}
+		payloadOptions.DebugSample = n
+		payloadOptions.DebugSampleRedactHeaders = slices.Clone(redactHeaders)
+		return nil
+	}
+}
+
+// debugSampleSize returns how many messages of the invocation are sampled, through the option or the DebugSampleInput.
+func (payloadOptions *PayloadOptions) debugSampleSize(inputs map[string]string) (int, error) {
+	value, ok := inputs[DebugSampleInput]
+	if !ok {
+		return payloadOptions.DebugSample, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s input %q, expected a number of messages", DebugSampleInput, value)
+	}
+	return n, nil
+}
+
+// logDebugSample logs the first n messages of the event.
+func (payloadOptions *PayloadOptions) logDebugSample(ctx context.Context, event *MemphisEvent, n int) {
+	if n == 0 {
+		return
+	}
+
+	logger := payloadOptions.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if requestID, ok := awsRequestID(ctx); ok {
+		logger = logger.With("aws_request_id", requestID)
+	}
+
+	for i, msg := range event.Messages[:min(n, len(event.Messages))] {
+		attrs := []any{
+			"message_index", i,
+			"payload", truncate(msg.Payload, debugSampleMaxBytes),
+			"headers", payloadOptions.redactHeaders(msg.Headers),
+		}
+		if payload, err := payloadOptions.decodePayload(msg.Payload); err != nil {
+			attrs = append(attrs, "decode_error", err.Error())
+		} else {
+			attrs = append(attrs, "decoded_size", len(payload), "decoded", hex.Dump(payload[:min(len(payload), debugSampleMaxBytes)]))
+		}
+		logger.InfoContext(ctx, "debug sample", attrs...)
+	}
+}
+
+// redactHeaders returns a copy of headers with the values of the headers to redact replaced.
+func (payloadOptions *PayloadOptions) redactHeaders(headers map[string]string) map[string]string {
+	redacted := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if slices.ContainsFunc(payloadOptions.DebugSampleRedactHeaders, func(redact string) bool { return strings.EqualFold(name, redact) }) {
+			value = redactedHeader
+		}
+		redacted[name] = value
+	}
+	return redacted
+}
+
+// truncate returns s cut to max bytes.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max] + "..."
+}
@@ -0,0 +1,59 @@
+package functions_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go_template/functions"
+	"go_template/memphistest"
+)
+
+func TestDecodeJSONWithoutSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    any
+	}{
+		{name: "object", payload: `{"a":1}`, want: map[string]any{"a": float64(1)}},
+		{name: "array", payload: `[1,"b"]`, want: []any{float64(1), "b"}},
+		{name: "string", payload: `"s"`, want: "s"},
+		{name: "number", payload: `3`, want: float64(3)},
+		{name: "bool", payload: `true`, want: true},
+		{name: "null", payload: `null`, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got any
+			handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				got = payload
+				return []byte("ok"), headers, nil
+			}, functions.PayloadInfo(nil, functions.JSON))
+			event := memphistest.NewEvent().AddMessage([]byte(tt.payload), nil).Build(t)
+
+			out, err := handler(context.Background(), event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(out.FailedMessages) != 0 {
+				t.Fatalf("message failed: %s", out.FailedMessages[0].Error)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("handler got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalidJSONWithoutSchema(t *testing.T) {
+	handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		return payload, headers, nil
+	}, functions.PayloadInfo(nil, functions.JSON))
+	event := memphistest.NewEvent().AddMessage([]byte(`{"a":`), nil).Build(t)
+
+	out, err := handler(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memphistest.RequireFailed(t, out, 0, "couldn't unmarshal JSON message")
+}
@@ -0,0 +1,180 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// WithDedupHeader emits only the first of the messages of an event that share the same value of the header,
+// e.g. an idempotency key, so a message a producer sent twice in the same batch is processed once.
+// The duplicates are filtered out of the station, or failed with WithFailDuplicates, and counted in the Stats.
+// Messages without the header, or with an empty value, are always processed.
+func WithDedupHeader(header string) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if header == "" {
+			return fmt.Errorf("dedup header can't be empty")
+		}
+		payloadOptions.DedupHeader = header
+		return nil
+	}
+}
+
+// WithFailDuplicates fails the duplicates found by WithDedupHeader or WithDedupStore instead of filtering them out of the station.
+func WithFailDuplicates() PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.FailDuplicates = true
+		return nil
+	}
+}
+
+// findDuplicates tells which messages repeat the dedup header value of an earlier message of the event.
+// It returns nil when WithDedupHeader isn't set.
+func (payloadOptions *PayloadOptions) findDuplicates(messages []MemphisMsg) []bool {
+	if payloadOptions.DedupHeader == "" {
+		return nil
+	}
+
+	duplicates := make([]bool, len(messages))
+	seen := make(map[string]struct{}, len(messages))
+	for i, msg := range messages {
+		key := Headers(msg.Headers).Get(payloadOptions.DedupHeader)
+		if key == "" {
+			continue
+		}
+		if _, ok := seen[key]; ok {
+			duplicates[i] = true
+			continue
+		}
+		seen[key] = struct{}{}
+	}
+
+	return duplicates
+}
+
+// duplicateResult is the result of a message suppressed as a duplicate, without decoding it nor calling the handler.
+// header is the one that holds the key msg was found a duplicate by.
+func (payloadOptions *PayloadOptions) duplicateResult(msg MemphisMsg, header string) messageResult {
+	result := messageResult{duplicate: true}
+	if payloadOptions.FailDuplicates {
+		key := Headers(msg.Headers).Get(header)
+		result = payloadOptions.failedResult(msg, DuplicateErrorCategory, fmt.Errorf("duplicate of an earlier message with %s %q", header, key))
+		result.duplicate = true
+	}
+	return result
+}
+
+// DedupStore remembers the keys of the messages already processed across invocations, so a redelivered batch isn't processed twice.
+// NewMemoryDedupStore only remembers the keys seen by the Lambda instance, a store shared by all the instances can be plugged instead,
+// e.g. a DynamoDB table with the key as partition key and a TTL attribute, with Mark as a PutItem and Seen as a consistent GetItem
+// that ignores expired items, or Redis with Mark as SET key 1 PX ttl and Seen as EXISTS key.
+type DedupStore interface {
+	// Seen tells whether key was marked and didn't expire yet.
+	Seen(ctx context.Context, key string) (bool, error)
+	// Mark remembers key for ttl, or forever when ttl is 0.
+	Mark(ctx context.Context, key string, ttl time.Duration) error
+}
+
+// WithDedupStore suppresses the messages whose header value was already marked in store, like duplicates of WithDedupHeader.
+// The value of a message is marked for ttl once the message was processed without failing and emitted, dry runs don't mark anything.
+// Messages are processed when store fails, unless WithDedupStoreFailClosed is set, and failing to mark one is only logged.
+func WithDedupStore(store DedupStore, header string, ttl time.Duration) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if store == nil {
+			return fmt.Errorf("dedup store can't be nil")
+		}
+		if header == "" {
+			return fmt.Errorf("dedup store header can't be empty")
+		}
+		if ttl < 0 {
+			return fmt.Errorf("dedup store ttl can't be negative: %v", ttl)
+		}
+		payloadOptions.DedupStore = store
+		payloadOptions.DedupStoreHeader = header
+		payloadOptions.DedupStoreTTL = ttl
+		return nil
+	}
+}
+
+// WithDedupStoreFailClosed returns the messages as UnprocessedMessages when the store of WithDedupStore can't tell if they were seen,
+// instead of processing them at the risk of a duplicate.
+func WithDedupStoreFailClosed() PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.DedupStoreFailClosed = true
+		return nil
+	}
+}
+
+// checkDedupStore returns the dedup store key of msg and whether it was already seen, the key is empty when there is nothing to check.
+func (payloadOptions *PayloadOptions) checkDedupStore(ctx context.Context, msg MemphisMsg) (string, bool, error) {
+	if payloadOptions.DedupStore == nil {
+		return "", false, nil
+	}
+	key := Headers(msg.Headers).Get(payloadOptions.DedupStoreHeader)
+	if key == "" {
+		return "", false, nil
+	}
+
+	seen, err := payloadOptions.DedupStore.Seen(ctx, key)
+	if err != nil {
+		if payloadOptions.DedupStoreFailClosed {
+			return "", false, Retryable(fmt.Errorf("not processed: dedup store: %w", err))
+		}
+		if payloadOptions.Logger != nil {
+			Logger(ctx).WarnContext(ctx, "dedup store failed, processing the message", "error", err)
+		}
+		return key, false, nil
+	}
+
+	return key, seen, nil
+}
+
+// markProcessed marks in the dedup store the keys of the messages that didn't fail.
+func (payloadOptions *PayloadOptions) markProcessed(ctx context.Context, results []messageResult) {
+	for i, result := range results {
+		if result.dedupKey == "" || result.failedMessage != nil {
+			continue
+		}
+		if err := payloadOptions.DedupStore.Mark(ctx, result.dedupKey, payloadOptions.DedupStoreTTL); err != nil && payloadOptions.Logger != nil {
+			payloadOptions.Logger.WarnContext(ctx, "dedup store failed to mark the message", "message_index", i, "error", err)
+		}
+	}
+}
+
+// MemoryDedupStore is a DedupStore that keeps the keys in memory, so they are only shared by the invocations of a Lambda instance.
+type MemoryDedupStore struct {
+	mu      sync.Mutex
+	expires map[string]time.Time // zero for keys that never expire
+}
+
+// NewMemoryDedupStore returns an empty MemoryDedupStore.
+func NewMemoryDedupStore() *MemoryDedupStore {
+	return &MemoryDedupStore{expires: make(map[string]time.Time)}
+}
+
+// Seen tells whether key was marked and didn't expire yet.
+func (store *MemoryDedupStore) Seen(_ context.Context, key string) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	expires, ok := store.expires[key]
+	if ok && !expires.IsZero() && !time.Now().Before(expires) {
+		delete(store.expires, key)
+		return false, nil
+	}
+	return ok, nil
+}
+
+// Mark remembers key for ttl, or forever when ttl is 0.
+func (store *MemoryDedupStore) Mark(_ context.Context, key string, ttl time.Duration) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+	store.expires[key] = expires
+	return nil
+}
@@ -0,0 +1,17 @@
+package functions
+
+// DestinationHeader is the header of the station an output message should be routed to, set by SetDestination.
+const DestinationHeader = "x-memphis-destination"
+
+// SetDestination tags an output message with headers for the station it should be routed to,
+// which also sets its MemphisMsg.Destination in the output so the platform doesn't have to parse the headers.
+// It returns headers, allocated if they were nil. Messages without a destination go where the function's output goes.
+func SetDestination(headers map[string]string, station string) map[string]string {
+	if headers == nil {
+		headers = make(map[string]string, 1)
+	}
+	// set under DestinationHeader itself rather than with Set, which would canonicalize the key
+	Headers(headers).Del(DestinationHeader)
+	headers[DestinationHeader] = station
+	return headers
+}
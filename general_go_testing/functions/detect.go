@@ -0,0 +1,154 @@
+package functions
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// PayloadFormatHeader is the header WithFormatDetection records the detected format of a message in.
+const PayloadFormatHeader = "x-payload-format"
+
+var payloadTypeNames = map[PayloadTypes]string{
+	BYTES:       "BYTES",
+	JSON:        "JSON",
+	PROTOBUF:    "PROTOBUF",
+	AVRO:        "AVRO",
+	MSGPACK:     "MSGPACK",
+	CBOR:        "CBOR",
+	XML:         "XML",
+	YAML:        "YAML",
+	TEXT:        "TEXT",
+	GOB:         "GOB",
+	FLATBUFFERS: "FLATBUFFERS",
+}
+
+func (payloadType PayloadTypes) String() string {
+	if name, ok := payloadTypeNames[payloadType]; ok {
+		return name
+	}
+	return fmt.Sprintf("PayloadTypes(%d)", int(payloadType))
+}
+
+// formatDetectors tell whether a payload is in their format, TEXT is only picked when no other format matches.
+var formatDetectors = map[PayloadTypes]func([]byte) bool{
+	JSON:    isJSON,
+	MSGPACK: isMsgpack,
+	CBOR:    isCBOR,
+	XML:     isXML,
+	TEXT:    utf8.Valid,
+}
+
+// WithFormatDetection decodes every message according to the first bytes of its payload, among formats,
+// and records the detected format in the x-payload-format header. Outputs are marshaled to the detected format.
+// JSON, MSGPACK, CBOR, XML and TEXT can be detected, TEXT being picked only when no other format matches.
+// Payloads matching none or several of the formats are failed.
+func WithFormatDetection(formats ...PayloadTypes) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if len(formats) == 0 {
+			return fmt.Errorf("at least one format to detect is needed")
+		}
+		for _, format := range formats {
+			if _, ok := formatDetectors[format]; !ok {
+				return fmt.Errorf("format %v can't be detected", format)
+			}
+		}
+		payloadOptions.DetectFormats = formats
+		return nil
+	}
+}
+
+// detectFormat returns the format of payload among the DetectFormats.
+func (payloadOptions *PayloadOptions) detectFormat(payload []byte) (PayloadTypes, error) {
+	if bytes.HasPrefix(payload, []byte{0x1f, 0x8b}) {
+		return 0, errors.New("payload is gzip compressed, use WithCompression")
+	}
+
+	var matched []PayloadTypes
+	tried := make([]string, 0, len(payloadOptions.DetectFormats))
+	text := false
+	for _, format := range payloadOptions.DetectFormats {
+		tried = append(tried, format.String())
+		if !formatDetectors[format](payload) {
+			continue
+		}
+		if format == TEXT {
+			text = true
+			continue
+		}
+		matched = append(matched, format)
+	}
+
+	switch {
+	case len(matched) == 1:
+		return matched[0], nil
+	case len(matched) > 1:
+		names := make([]string, len(matched))
+		for i, format := range matched {
+			names[i] = format.String()
+		}
+		return 0, fmt.Errorf("ambiguous payload format, matches %s", strings.Join(names, ", "))
+	case text:
+		return TEXT, nil
+	default:
+		return 0, fmt.Errorf("unrecognized payload format, tried %s", strings.Join(tried, ", "))
+	}
+}
+
+func isJSON(payload []byte) bool {
+	trimmed := bytes.TrimLeft(payload, " \t\r\n")
+	return len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed)
+}
+
+func isMsgpack(payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+	switch first := payload[0]; {
+	case first >= 0x80 && first <= 0x9f, first >= 0xdc && first <= 0xdf: // maps and arrays
+	default:
+		return false
+	}
+	decoder := msgpack.NewDecoder(bytes.NewReader(payload))
+	if _, err := decoder.DecodeInterface(); err != nil {
+		return false
+	}
+	_, err := decoder.DecodeInterface()
+	return err == io.EOF // a single value, nothing after it
+}
+
+func isCBOR(payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+	// maps and arrays, or the self-described CBOR tag
+	if majorType := payload[0] >> 5; majorType != 4 && majorType != 5 && !bytes.HasPrefix(payload, []byte{0xd9, 0xd9, 0xf7}) {
+		return false
+	}
+	return cbor.Wellformed(payload) == nil
+}
+
+func isXML(payload []byte) bool {
+	trimmed := bytes.TrimLeft(payload, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '<' {
+		return false
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(trimmed))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package functions
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"time"
+)
+
+// The metrics written by WithEMFMetrics for every invocation, with their units.
+const (
+	// EMFProcessedMetric counts the messages that emitted at least one message.
+	EMFProcessedMetric = "Processed"
+	// EMFFailedMetric counts the messages that failed.
+	EMFFailedMetric = "Failed"
+	// EMFFilteredMetric counts the messages that emitted none without failing.
+	EMFFilteredMetric = "Filtered"
+	// EMFUnprocessedMetric counts the messages left unprocessed, to be retried.
+	EMFUnprocessedMetric = "Unprocessed"
+	// EMFHandlerDurationMetric is the average time the handler took for the messages that reached it.
+	EMFHandlerDurationMetric = "HandlerDuration"
+
+	// EMFCountUnit is the unit of the counts of messages.
+	EMFCountUnit = "Count"
+	// EMFMillisecondsUnit is the unit of the durations.
+	EMFMillisecondsUnit = "Milliseconds"
+)
+
+// emfMaxDimensions is the maximum number of dimensions of a metric allowed by CloudWatch.
+const emfMaxDimensions = 30
+
+// emfOutput is where the EMF metrics are written, Lambda sends its stdout to CloudWatch Logs.
+var emfOutput io.Writer = os.Stdout
+
+var emfMetrics = []emfMetric{
+	{Name: EMFProcessedMetric, Unit: EMFCountUnit},
+	{Name: EMFFailedMetric, Unit: EMFCountUnit},
+	{Name: EMFFilteredMetric, Unit: EMFCountUnit},
+	{Name: EMFUnprocessedMetric, Unit: EMFCountUnit},
+	{Name: EMFHandlerDurationMetric, Unit: EMFMillisecondsUnit},
+}
+
+// WithEMFMetrics writes the metrics of every invocation to stdout in the CloudWatch Embedded Metric Format,
+// so CloudWatch extracts them from the logs of the function under namespace, with dimensions.
+func WithEMFMetrics(namespace string, dimensions map[string]string) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if namespace == "" {
+			return fmt.Errorf("EMF namespace can't be empty")
+		}
+		if len(dimensions) > emfMaxDimensions {
+			return fmt.Errorf("EMF metrics can have up to %d dimensions, got %d", emfMaxDimensions, len(dimensions))
+		}
+		for name := range dimensions {
+			if name == "_aws" || slices.ContainsFunc(emfMetrics, func(metric emfMetric) bool { return metric.Name == name }) {
+				return fmt.Errorf("EMF dimension %q is reserved", name)
+			}
+		}
+
+		payloadOptions.EMFNamespace = namespace
+		payloadOptions.EMFDimensions = maps.Clone(dimensions)
+		return nil
+	}
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfMetricDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64                `json:"Timestamp"`
+	CloudWatchMetrics []emfMetricDirective `json:"CloudWatchMetrics"`
+}
+
+// writeEMFMetrics writes the metrics of the results of an invocation, it does nothing unless WithEMFMetrics is set.
+func (payloadOptions *PayloadOptions) writeEMFMetrics(results []messageResult) {
+	if payloadOptions.EMFNamespace == "" {
+		return
+	}
+
+	stats := newStats(results)
+	var handled int
+	for _, result := range results {
+		if result.handlerDuration > 0 {
+			handled++
+		}
+	}
+	var handlerDuration float64
+	if handled > 0 {
+		handlerDuration = float64(stats.TotalHandlerTime) / float64(handled) / float64(time.Millisecond)
+	}
+
+	dimensionNames := slices.Sorted(maps.Keys(payloadOptions.EMFDimensions))
+	if dimensionNames == nil {
+		dimensionNames = []string{}
+	}
+
+	blob := make(map[string]any, len(payloadOptions.EMFDimensions)+len(emfMetrics)+1)
+	for name, value := range payloadOptions.EMFDimensions {
+		blob[name] = value
+	}
+	blob[EMFProcessedMetric] = stats.Processed
+	blob[EMFFailedMetric] = stats.Failed
+	blob[EMFFilteredMetric] = stats.Filtered
+	blob[EMFUnprocessedMetric] = stats.Unprocessed
+	blob[EMFHandlerDurationMetric] = handlerDuration
+	blob["_aws"] = emfMetadata{
+		Timestamp: time.Now().UnixMilli(),
+		CloudWatchMetrics: []emfMetricDirective{{
+			Namespace:  payloadOptions.EMFNamespace,
+			Dimensions: [][]string{dimensionNames},
+			Metrics:    emfMetrics,
+		}},
+	}
+
+	data, err := json.Marshal(blob)
+	if err != nil {
+		return
+	}
+	// a single write keeps the blob on one line even when invocations run concurrently
+	emfOutput.Write(append(data, '\n'))
+}
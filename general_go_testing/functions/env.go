@@ -0,0 +1,117 @@
+package functions
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Environment variables read by OptionsFromEnv, unset or empty ones are ignored.
+const (
+	// DryRunEnv is a boolean, see WithDryRun.
+	DryRunEnv = "MEMPHIS_FUNC_DRY_RUN"
+	// MaxPayloadSizeEnv is a number of bytes, see WithMaxPayloadSize.
+	MaxPayloadSizeEnv = "MEMPHIS_FUNC_MAX_PAYLOAD_SIZE"
+	// StrictJSONEnv is a boolean, see WithStrictJSON.
+	StrictJSONEnv = "MEMPHIS_FUNC_STRICT_JSON"
+	// StatsEnv is a boolean, see WithStats.
+	StatsEnv = "MEMPHIS_FUNC_STATS"
+	// MessageTimeoutEnv is a duration such as "5s", see WithMessageTimeout.
+	MessageTimeoutEnv = "MEMPHIS_FUNC_MESSAGE_TIMEOUT"
+	// MaxConcurrencyEnv is a number of messages, see WithMaxConcurrency.
+	MaxConcurrencyEnv = "MEMPHIS_FUNC_MAX_CONCURRENCY"
+	// OnFailureEnv is one of "dead_letter", "drop" or "passthrough", see WithOnFailure.
+	OnFailureEnv = "MEMPHIS_FUNC_ON_FAILURE"
+	// DefaultErrorClassificationEnv is one of "retryable" or "permanent", see WithDefaultErrorClassification.
+	DefaultErrorClassificationEnv = "MEMPHIS_FUNC_DEFAULT_ERROR_CLASSIFICATION"
+)
+
+// failurePolicyNames are the values of the OnFailureEnv.
+var failurePolicyNames = map[string]FailurePolicy{
+	"dead_letter": DeadLetter,
+	"drop":        DropFailures,
+	"passthrough": PassthroughOriginal,
+}
+
+// OptionsFromEnv applies the options set by the MEMPHIS_FUNC_* environment variables, e.g. MEMPHIS_FUNC_DRY_RUN=true,
+// so the same function can behave differently per deployment. The other options take precedence over them,
+// wherever OptionsFromEnv is in the list. An invalid value fails the invocations with an error naming the variable.
+func OptionsFromEnv() PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.FromEnv = true
+		return nil
+	}
+}
+
+// envOptions returns the options set by the environment variables.
+func envOptions() ([]PayloadOption, error) {
+	var options []PayloadOption
+	parsers := []struct {
+		name  string
+		parse func(value string) (PayloadOption, error)
+	}{
+		{DryRunEnv, func(value string) (PayloadOption, error) {
+			dryRun, err := strconv.ParseBool(value)
+			return WithDryRun(dryRun), err
+		}},
+		{MaxPayloadSizeEnv, func(value string) (PayloadOption, error) {
+			maxBytes, err := strconv.Atoi(value)
+			return WithMaxPayloadSize(maxBytes), err
+		}},
+		{StrictJSONEnv, func(value string) (PayloadOption, error) {
+			strict, err := strconv.ParseBool(value)
+			return func(payloadOptions *PayloadOptions) error {
+				payloadOptions.StrictJSON = strict
+				return nil
+			}, err
+		}},
+		{StatsEnv, func(value string) (PayloadOption, error) {
+			stats, err := strconv.ParseBool(value)
+			return func(payloadOptions *PayloadOptions) error {
+				payloadOptions.Stats = stats
+				return nil
+			}, err
+		}},
+		{MessageTimeoutEnv, func(value string) (PayloadOption, error) {
+			d, err := time.ParseDuration(value)
+			return WithMessageTimeout(d), err
+		}},
+		{MaxConcurrencyEnv, func(value string) (PayloadOption, error) {
+			n, err := strconv.Atoi(value)
+			return WithMaxConcurrency(n), err
+		}},
+		{OnFailureEnv, func(value string) (PayloadOption, error) {
+			policy, ok := failurePolicyNames[value]
+			if !ok {
+				return nil, fmt.Errorf("unknown failure policy %q, expected dead_letter, drop or passthrough", value)
+			}
+			return WithOnFailure(policy), nil
+		}},
+		{DefaultErrorClassificationEnv, func(value string) (PayloadOption, error) {
+			classification := ErrorClassification(value)
+			if classification != RetryableClassification && classification != PermanentClassification {
+				return nil, fmt.Errorf("unknown error classification %q, expected retryable or permanent", value)
+			}
+			return WithDefaultErrorClassification(classification), nil
+		}},
+	}
+
+	for _, parser := range parsers {
+		value := os.Getenv(parser.name)
+		if value == "" {
+			continue
+		}
+		option, err := parser.parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s=%q: %w", parser.name, value, err)
+		}
+		// checked right away, so the error names the variable
+		if err := option(&PayloadOptions{}); err != nil {
+			return nil, fmt.Errorf("invalid %s=%q: %w", parser.name, value, err)
+		}
+		options = append(options, option)
+	}
+
+	return options, nil
+}
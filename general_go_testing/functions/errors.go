@@ -0,0 +1,395 @@
+package functions
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Categories of the stage a failed message failed at, recorded in MemphisMsgWithError.Category.
+const (
+	PayloadSizeErrorCategory    = "payload_size"
+	DecodeErrorCategory         = "decode"
+	ClaimCheckErrorCategory     = "claim_check"
+	DecompressErrorCategory     = "decompress"
+	DecryptErrorCategory        = "decrypt"
+	InputSchemaErrorCategory    = "input_schema"
+	SchemaRegistryErrorCategory = "schema_registry"
+	HandlerErrorCategory        = "handler"
+	MarshalErrorCategory        = "marshal"
+	OutputSchemaErrorCategory   = "output_schema"
+	NotProcessedErrorCategory   = "not_processed"
+	DuplicateErrorCategory      = "duplicate"
+	InvalidUTF8ErrorCategory    = "invalid_utf8"
+)
+
+// ErrFilterMessage can be returned by handlers to filter the message out of the station on purpose.
+var ErrFilterMessage = errors.New("message filtered")
+
+// Error can be returned by handlers to attach a code and details to the failed message in the dead-letter station.
+type Error struct {
+	Code    string
+	Message string
+	Details map[string]any
+}
+
+// NewError creates an Error with the given code and message, details can be added with WithDetail.
+func NewError(code string, message string) *Error {
+	return &Error{
+		Code:    code,
+		Message: message,
+	}
+}
+
+// WithDetail adds a detail to the error and returns it so calls can be chained.
+func (e *Error) WithDetail(key string, value any) *Error {
+	if e.Details == nil {
+		e.Details = make(map[string]any)
+	}
+	e.Details[key] = value
+	return e
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// Default limits of the error strings of the failed messages, see WithErrorLimits and WithMaxReportedErrors.
+const (
+	DefaultMaxErrorSize       = 4 << 10
+	DefaultMaxTotalErrorsSize = 256 << 10
+	DefaultMaxReportedErrors  = 1000
+)
+
+// WithErrorLimits truncates the error of every failed message to maxSize bytes, and the errors of the failed messages
+// of an invocation to maxTotalSize bytes together, so a handler error wrapping a huge body can't blow up the output.
+// Truncated errors end with an ellipsis and their original length as far as the limit allows, 0 disables a limit.
+// maxSize also applies to the PassthroughErrorHeader. The defaults are DefaultMaxErrorSize and DefaultMaxTotalErrorsSize.
+func WithErrorLimits(maxSize, maxTotalSize int) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if maxSize < 0 || maxTotalSize < 0 {
+			return fmt.Errorf("error limits can't be negative, got %d and %d", maxSize, maxTotalSize)
+		}
+		payloadOptions.MaxErrorSize = maxSize
+		payloadOptions.MaxTotalErrorsSize = maxTotalSize
+		return nil
+	}
+}
+
+// WithMaxReportedErrors reports the errors of the first n failed messages of an invocation only,
+// the following ones are still failed but their error just says it wasn't reported. 0 disables the limit,
+// which defaults to DefaultMaxReportedErrors.
+func WithMaxReportedErrors(n int) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if n < 0 {
+			return fmt.Errorf("max reported errors can't be negative, got %d", n)
+		}
+		payloadOptions.MaxReportedErrors = n
+		return nil
+	}
+}
+
+// limitErrors limits the errors of the failed messages to the limits of WithMaxReportedErrors and WithErrorLimits, in order,
+// so once the total limit is reached the remaining errors are emptied.
+func (payloadOptions *PayloadOptions) limitErrors(failedMessages []MemphisMsgWithError) {
+	if payloadOptions.MaxReportedErrors > 0 {
+		for i := payloadOptions.MaxReportedErrors; i < len(failedMessages); i++ {
+			failedMessages[i].Error = fmt.Sprintf("error not reported, more than %d messages failed", payloadOptions.MaxReportedErrors)
+		}
+	}
+	if payloadOptions.MaxErrorSize == 0 && payloadOptions.MaxTotalErrorsSize == 0 {
+		return
+	}
+
+	remaining := payloadOptions.MaxTotalErrorsSize
+	for i := range failedMessages {
+		limit := payloadOptions.MaxErrorSize
+		if payloadOptions.MaxTotalErrorsSize > 0 && (limit == 0 || remaining < limit) {
+			limit = remaining
+		}
+		failedMessages[i].Error = truncateError(failedMessages[i].Error, limit)
+		remaining = max(remaining-len(failedMessages[i].Error), 0)
+	}
+}
+
+// truncateError cuts err to at most limit bytes including the note of its original length, without splitting a rune.
+// When the note itself doesn't fit, it is cut to limit.
+func truncateError(err string, limit int) string {
+	if len(err) <= limit {
+		return err
+	}
+
+	note := fmt.Sprintf("... (truncated, %d bytes)", len(err))
+	if limit <= len(note) {
+		return note[:limit]
+	}
+	cut := limit - len(note)
+	for cut > 0 && !utf8.RuneStart(err[cut]) {
+		cut--
+	}
+	return err[:cut] + note
+}
+
+// newMsgWithError builds the failed message of msg, filling the structured fields from err.
+// errors that weren't classified by the handler get the defaultClassification.
+func newMsgWithError(msg MemphisMsg, category string, err error, defaultClassification ErrorClassification) *MemphisMsgWithError {
+	failedMessage := &MemphisMsgWithError{
+		Headers:        msg.Headers,
+		Payload:        msg.Payload,
+		Error:          err.Error(),
+		Category:       category,
+		Classification: Classification(err),
+	}
+	if failedMessage.Classification == Unclassified {
+		failedMessage.Classification = defaultClassification
+	}
+
+	var memphisErr *Error
+	if errors.As(err, &memphisErr) {
+		failedMessage.Code = memphisErr.Code
+		failedMessage.Details = memphisErr.Details
+	}
+
+	return failedMessage
+}
+
+// ErrorClassification tells whether a failed message may succeed if it is redelivered.
+type ErrorClassification string
+
+const (
+	Unclassified            ErrorClassification = ""
+	RetryableClassification ErrorClassification = "retryable"
+	PermanentClassification ErrorClassification = "permanent"
+)
+
+type classifiedError struct {
+	err            error
+	classification ErrorClassification
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+// Retryable marks err as transient, e.g. a timeout calling another service, so the message can be redelivered.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{err: err, classification: RetryableClassification}
+}
+
+// Permanent marks err as one that will fail again on every redelivery, e.g. a schema violation.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{err: err, classification: PermanentClassification}
+}
+
+// Classification returns the classification err was wrapped with by Retryable or Permanent, if any.
+func Classification(err error) ErrorClassification {
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		return classified.classification
+	}
+	return Unclassified
+}
+
+// AttemptsHeader is the header of the delivery attempt number of a message, reported in the FailureMetadata.
+const AttemptsHeader = "attempts"
+
+// FailureMetadata tells when and by which function a message failed, it is set on failed messages with WithFailureMetadata.
+type FailureMetadata struct {
+	ProcessedAt     time.Time     `json:"processed_at"`
+	FunctionName    string        `json:"function_name,omitempty"`
+	FunctionVersion string        `json:"function_version,omitempty"`
+	HandlerDuration time.Duration `json:"handler_duration_ns"`
+	// Attempt is the value of the attempts header, 0 when it is missing
+	Attempt int `json:"attempt,omitempty"`
+}
+
+// WithFailureMetadata adds FailureMetadata to the messages sent to the dead-letter station.
+func WithFailureMetadata() PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.FailureMetadata = true
+		return nil
+	}
+}
+
+func newFailureMetadata(msg MemphisMsg, processedAt time.Time, handlerDuration time.Duration) *FailureMetadata {
+	metadata := &FailureMetadata{
+		ProcessedAt:     processedAt.UTC(),
+		FunctionName:    functionName(),
+		FunctionVersion: functionVersion(),
+		HandlerDuration: handlerDuration,
+	}
+	if attempt, err := strconv.Atoi(Headers(msg.Headers).Get(AttemptsHeader)); err == nil {
+		metadata.Attempt = attempt
+	}
+	return metadata
+}
+
+// FailurePolicy tells what happens to the messages that fail.
+type FailurePolicy int
+
+const (
+	// DeadLetter sends failed messages to the dead-letter station, it is the default.
+	DeadLetter FailurePolicy = iota
+	// DropFailures drops failed messages, they are still counted in the Stats and logged at Warn level.
+	DropFailures
+	// PassthroughOriginal emits failed messages unchanged, they are still counted in the Stats and logged at Warn level.
+	// Unless WithOnDecodeFailure says otherwise, messages that fail before reaching the handler are still dead-lettered.
+	PassthroughOriginal
+)
+
+// WithOnFailure sets the policy applied to the messages that fail, whether they fail to decode, in the handler or to be marshaled.
+func WithOnFailure(policy FailurePolicy) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.OnFailure = policy
+		return nil
+	}
+}
+
+// WithOnDecodeFailure sets the policy applied to the messages that fail before reaching the handler,
+// because they are too large, can't be decoded, fetched, decompressed, decrypted or violate the input schema, instead of the WithOnFailure one.
+func WithOnDecodeFailure(policy FailurePolicy) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.OnDecodeFailure = &policy
+		return nil
+	}
+}
+
+// WithPassthroughErrorHeader sets the header name, e.g. x-enrichment-error, to the error of the messages passed through by PassthroughOriginal.
+func WithPassthroughErrorHeader(name string) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.PassthroughErrorHeader = name
+		return nil
+	}
+}
+
+// failurePolicy returns the policy of messages failed with category.
+func (payloadOptions *PayloadOptions) failurePolicy(category string) FailurePolicy {
+	switch category {
+	case PayloadSizeErrorCategory, DecodeErrorCategory, ClaimCheckErrorCategory, DecompressErrorCategory, DecryptErrorCategory, InputSchemaErrorCategory, SchemaRegistryErrorCategory:
+		if payloadOptions.OnDecodeFailure != nil {
+			return *payloadOptions.OnDecodeFailure
+		}
+		if payloadOptions.OnFailure == PassthroughOriginal {
+			return DeadLetter
+		}
+	}
+	return payloadOptions.OnFailure
+}
+
+// passthroughMessage returns the original message of failedMessage, with the PassthroughErrorHeader if one is set,
+// its value truncated to the MaxErrorSize.
+func (payloadOptions *PayloadOptions) passthroughMessage(failedMessage *MemphisMsgWithError) MemphisMsg {
+	headers := failedMessage.Headers
+	if payloadOptions.PassthroughErrorHeader != "" {
+		headers = copyHeaders(headers)
+		if headers == nil {
+			headers = make(map[string]string, 1)
+		}
+		errorValue := failedMessage.Error
+		if payloadOptions.MaxErrorSize > 0 {
+			errorValue = truncateError(errorValue, payloadOptions.MaxErrorSize)
+		}
+		headers[payloadOptions.PassthroughErrorHeader] = errorValue
+	}
+	return MemphisMsg{Headers: headers, Payload: failedMessage.Payload}
+}
+
+// MessageError is the error of a failed message, with the index of the message in the event
+// and the values of the headers named by WithErrorContextHeaders. It unwraps to the original error.
+type MessageError struct {
+	Index   int
+	Headers map[string]string
+	// names of the headers, in the order they are reported in
+	headerNames []string
+	Err         error
+}
+
+func (e *MessageError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "message %d", e.Index)
+	if len(e.headerNames) > 0 {
+		b.WriteString(" (")
+		for i, name := range e.headerNames {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "%s=%s", name, e.Headers[name])
+		}
+		b.WriteString(")")
+	}
+	b.WriteString(": ")
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *MessageError) Unwrap() error {
+	return e.Err
+}
+
+// WithErrorContextHeaders adds the values of the headers to the errors of the failed messages, e.g. correlation IDs,
+// looked up case-insensitively. Headers missing from a message are left out.
+func WithErrorContextHeaders(names ...string) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.ErrorContextHeaders = append(payloadOptions.ErrorContextHeaders, names...)
+		return nil
+	}
+}
+
+// newMessageError wraps err, the error of msg, the message at index of the event.
+func (payloadOptions *PayloadOptions) newMessageError(index int, msg MemphisMsg, err error) *MessageError {
+	messageErr := &MessageError{Index: index, Err: err}
+	for _, name := range payloadOptions.ErrorContextHeaders {
+		if value, ok := Headers(msg.Headers).lookup(name); ok {
+			if messageErr.Headers == nil {
+				messageErr.Headers = map[string]string{}
+			}
+			messageErr.Headers[name] = value
+			messageErr.headerNames = append(messageErr.headerNames, name)
+		}
+	}
+	return messageErr
+}
+
+// WithFailureThreshold fails the whole invocation, so that the event is retried later instead of being dead-lettered,
+// when the ratio of failed messages reaches ratio, e.g. because a dependency is down. Zero disables it.
+func WithFailureThreshold(ratio float64) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if ratio < 0 || ratio > 1 {
+			return fmt.Errorf("failure threshold must be between 0 and 1, got %v", ratio)
+		}
+		payloadOptions.FailureThreshold = ratio
+		return nil
+	}
+}
+
+// checkFailureThreshold returns an error with the counts when the FailureThreshold is reached.
+func (payloadOptions *PayloadOptions) checkFailureThreshold(results []messageResult) error {
+	if payloadOptions.FailureThreshold == 0 || len(results) == 0 {
+		return nil
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.outcome() == failedOutcome {
+			failed++
+		}
+	}
+	if float64(failed)/float64(len(results)) >= payloadOptions.FailureThreshold {
+		return fmt.Errorf("%d of %d messages failed, reaching the failure threshold of %v", failed, len(results), payloadOptions.FailureThreshold)
+	}
+
+	return nil
+}
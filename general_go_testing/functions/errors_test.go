@@ -0,0 +1,120 @@
+package functions_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"go_template/functions"
+	"go_template/memphistest"
+)
+
+func TestErrorLimitsKeepRunes(t *testing.T) {
+	// "é" is 2 bytes, so every odd limit falls in the middle of a rune
+	long := strings.Repeat("é", 100)
+	for limit := 40; limit <= 50; limit++ {
+		handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+			return nil, nil, errors.New(long)
+		}, functions.WithErrorLimits(limit, 0))
+		event := memphistest.NewEvent().AddMessage([]byte("payload"), nil).Build(t)
+
+		out, err := handler(context.Background(), event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		memphistest.RequireFailed(t, out, 0, "... (truncated, ")
+		truncated := out.FailedMessages[0].Error
+		if len(truncated) > limit {
+			t.Errorf("limit %d: error of %d bytes", limit, len(truncated))
+		}
+		if !utf8.ValidString(truncated) {
+			t.Errorf("limit %d: the truncation split a rune: %q", limit, truncated)
+		}
+	}
+}
+
+func TestErrorLimitsTotal(t *testing.T) {
+	handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		return nil, nil, errors.New(strings.Repeat("日本", 50))
+	}, functions.WithErrorLimits(0, 200))
+	builder := memphistest.NewEvent()
+	for range 3 {
+		builder.AddMessage([]byte("payload"), nil)
+	}
+
+	out, err := handler(context.Background(), builder.Build(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first error takes about the whole budget, the following ones get what is left of it
+	first := out.FailedMessages[0].Error
+	if len(first) > 200 || !utf8.ValidString(first) {
+		t.Fatalf("first error of %d bytes, want at most 200 without a split rune: %q", len(first), first)
+	}
+	total := 0
+	for _, failed := range out.FailedMessages {
+		total += len(failed.Error)
+	}
+	if total > 200 || out.FailedMessages[2].Error != "" {
+		t.Fatalf("errors of %d bytes together, want at most 200 and the last one emptied: %+v", total, out.FailedMessages)
+	}
+}
+
+func TestErrorLimitsShorterThanNote(t *testing.T) {
+	for _, limit := range []int{1, 3, 10, 25} {
+		handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+			return nil, nil, errors.New(strings.Repeat("e", 100))
+		}, functions.WithErrorLimits(limit, 0))
+		event := memphistest.NewEvent().AddMessage([]byte("payload"), nil).Build(t)
+
+		out, err := handler(context.Background(), event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if truncated := out.FailedMessages[0].Error; len(truncated) > limit || !strings.HasPrefix("... (truncated, 111 bytes)", truncated) {
+			t.Errorf("limit %d: error %q, want the note cut to the limit", limit, truncated)
+		}
+	}
+}
+
+func TestMaxReportedErrors(t *testing.T) {
+	handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		return nil, nil, errors.New("handler failed")
+	}, functions.WithMaxReportedErrors(2))
+	builder := memphistest.NewEvent()
+	for range 4 {
+		builder.AddMessage([]byte("payload"), nil)
+	}
+
+	out, err := handler(context.Background(), builder.Build(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.FailedMessages) != 4 {
+		t.Fatalf("got %d failed messages, want all of them", len(out.FailedMessages))
+	}
+	memphistest.RequireFailed(t, out, 0, "handler failed")
+	memphistest.RequireFailed(t, out, 1, "handler failed")
+	memphistest.RequireFailed(t, out, 2, "error not reported, more than 2 messages failed")
+	memphistest.RequireFailed(t, out, 3, "error not reported, more than 2 messages failed")
+}
+
+func TestPassthroughErrorHeaderLimit(t *testing.T) {
+	handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		return nil, nil, errors.New(strings.Repeat("e", 100))
+	}, functions.WithOnFailure(functions.PassthroughOriginal), functions.WithPassthroughErrorHeader("x-error"), functions.WithErrorLimits(50, 0))
+	event := memphistest.NewEvent().AddMessage([]byte("payload"), nil).Build(t)
+
+	out, err := handler(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 1 {
+		t.Fatalf("got %d emitted messages, want the passed through one", len(out.Messages))
+	}
+	if value := out.Messages[0].Headers["x-error"]; len(value) > 50 || !strings.HasSuffix(value, "... (truncated, 111 bytes)") {
+		t.Fatalf("error header %q, want it truncated to 50 bytes", value)
+	}
+}
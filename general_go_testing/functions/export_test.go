@@ -0,0 +1,25 @@
+package functions
+
+import (
+	"io"
+	"log/slog"
+)
+
+var ErrShuttingDown = errShuttingDown
+
+// RunShutdown runs the WithShutdown function of function as the SIGTERM handler does.
+func RunShutdown(function *Function) {
+	function.config.Shutdown.run(slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+// ShuttingDown tells whether the shutdown of function has begun.
+func ShuttingDown(function *Function) bool {
+	state := function.config.Shutdown
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.closed
+}
+
+func (payloadOptions *PayloadOptions) CompressOutput(payload []byte, headers map[string]string) ([]byte, map[string]string, error) {
+	return payloadOptions.compressOutput(payload, headers)
+}
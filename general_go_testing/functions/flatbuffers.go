@@ -0,0 +1,62 @@
+package functions
+
+import (
+	"fmt"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// FlatbuffersVerifier adds a schema specific check that runs after the root table verification of FLATBUFFERS payloads,
+// e.g. checking the offsets of nested tables, strings and vectors, which only the schema knows about, before reading them.
+func FlatbuffersVerifier(verifier func([]byte) error) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.FlatbuffersVerifier = verifier
+		return nil
+	}
+}
+
+// FlatbufferRootTable returns the root table of a verified buffer so its fields can be read without copying,
+// generated types can be initialized from it with their Init(table.Bytes, table.Pos) method.
+func FlatbufferRootTable(buf []byte) flatbuffers.Table {
+	return flatbuffers.Table{
+		Bytes: buf,
+		Pos:   flatbuffers.GetUOffsetT(buf),
+	}
+}
+
+// verifyFlatbuffer checks that the root table, its vtable and the field offsets of the vtable lie inside the buffer,
+// so the generated accessors can't index out of range when reading the scalar fields of the root table.
+// What the fields point to, nested tables, strings and vectors, isn't verified as that requires the schema.
+func verifyFlatbuffer(buf []byte) error {
+	bufLen := len(buf)
+	if bufLen < flatbuffers.SizeUOffsetT {
+		return fmt.Errorf("buffer of %d bytes is too small to hold a root offset", bufLen)
+	}
+
+	tablePos := int(flatbuffers.GetUOffsetT(buf))
+	if tablePos%flatbuffers.SizeUOffsetT != 0 || tablePos+flatbuffers.SizeSOffsetT > bufLen {
+		return fmt.Errorf("root table offset %d is out of bounds", tablePos)
+	}
+
+	vtablePos := tablePos - int(flatbuffers.GetSOffsetT(buf[tablePos:]))
+	if vtablePos < 0 || vtablePos%flatbuffers.SizeVOffsetT != 0 || vtablePos+2*flatbuffers.SizeVOffsetT > bufLen {
+		return fmt.Errorf("vtable offset %d is out of bounds", vtablePos)
+	}
+
+	vtableSize := int(flatbuffers.GetVOffsetT(buf[vtablePos:]))
+	tableSize := int(flatbuffers.GetVOffsetT(buf[vtablePos+flatbuffers.SizeVOffsetT:]))
+	if vtableSize < 2*flatbuffers.SizeVOffsetT || vtableSize%flatbuffers.SizeVOffsetT != 0 || vtablePos+vtableSize > bufLen {
+		return fmt.Errorf("vtable size %d is invalid", vtableSize)
+	}
+	if tableSize < flatbuffers.SizeSOffsetT || tablePos+tableSize > bufLen {
+		return fmt.Errorf("table size %d is invalid", tableSize)
+	}
+
+	for fieldPos := vtablePos + 2*flatbuffers.SizeVOffsetT; fieldPos < vtablePos+vtableSize; fieldPos += flatbuffers.SizeVOffsetT {
+		if fieldOffset := int(flatbuffers.GetVOffsetT(buf[fieldPos:])); fieldOffset >= tableSize {
+			return fmt.Errorf("field offset %d is outside of the table", fieldOffset)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,1191 @@
+// Package functions runs Memphis functions: it decodes the messages of every event, passes them to a handler
+// and encodes its results, on AWS Lambda with CreateFunction and its variants or over HTTP with ServeHTTP,
+// and as an Azure Functions custom handler or a Google Cloud Function with the azure and gcf packages.
+// Building with the gcf tag leaves the Lambda runtime out, CreateFunction and its variants aren't available then.
+package functions
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hamba/avro/v2"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type MemphisMsg struct {
+	Headers map[string]string `json:"headers"`
+	Payload string            `json:"payload"`
+	// Destination is the station set by SetDestination for an output message, empty for the default one.
+	Destination string `json:"destination,omitempty"`
+}
+
+type MemphisMsgWithError struct {
+	Headers map[string]string `json:"headers"`
+	Payload string            `json:"payload"`
+	Error   string            `json:"error"`
+
+	// structured description of Error, Code and Details are set when the handler returns an *Error
+	Code     string         `json:"code,omitempty"`
+	Category string         `json:"category,omitempty"`
+	Details  map[string]any `json:"details,omitempty"`
+
+	Classification ErrorClassification `json:"classification,omitempty"`
+
+	// set with WithFailureMetadata
+	Metadata *FailureMetadata `json:"metadata,omitempty"`
+}
+
+type MemphisEvent struct {
+	Inputs   map[string]string `json:"inputs"`
+	Messages []MemphisMsg      `json:"messages"`
+}
+
+// MemphisOutput's Messages and FailedMessages keep the relative order the messages had in the MemphisEvent,
+// no matter in which order their handlers finished.
+type MemphisOutput struct {
+	Messages       []MemphisMsg          `json:"messages"`
+	FailedMessages []MemphisMsgWithError `json:"failed_messages"`
+	Stats          *Stats                `json:"stats,omitempty"`
+
+	// UnprocessedMessages are the messages whose handler was never called, e.g. because of the deadline or an open circuit breaker,
+	// they should be redelivered rather than dead-lettered.
+	UnprocessedMessages []MemphisMsg `json:"unprocessed_messages,omitempty"`
+}
+
+// HandlerType functions get the message payload as []byte (or any, or string for TEXT), message headers as map[string]string and inputs as map[string]string and should return the modified payload and headers.
+// error should be returned if the message should be considered failed and go into the dead-letter station.
+// if all returned values are nil, or the error is ErrFilterMessage, the message will be filtered out of the station.
+// if only the payload or only the headers are nil, the original payload or headers of the message are kept.
+// returning a []OutMsg as the payload emits one message per element, an empty slice filters the message.
+type HandlerType func(any, map[string]string, map[string]string) (any, map[string]string, error)
+
+// OutMsg is a single output message, handlers can return a []OutMsg as the payload to emit several messages for one input.
+// Every payload is marshaled according to the PayloadType, nil Headers fall back to the headers returned alongside the slice.
+type OutMsg struct {
+	Payload any
+	Headers map[string]string
+}
+
+// HandlerWithContextType functions behave like HandlerType functions but also get the invocation's context,
+// which carries the Lambda deadline and should be passed on to any downstream calls.
+type HandlerWithContextType func(context.Context, any, map[string]string, map[string]string) (any, map[string]string, error)
+
+// Middleware wraps the handler of every message, e.g. for logging, timing or stamping headers.
+// It can short-circuit the handler by returning an error, or nil values to filter the message, without calling next.
+type Middleware func(next HandlerWithContextType) HandlerWithContextType
+
+type PayloadOption func(*PayloadOptions) error
+
+type PayloadOptions struct {
+	Handler     HandlerWithContextType
+	UserObject  any
+	PayloadType PayloadTypes
+	AvroSchema  avro.Schema
+
+	FlatbuffersVerifier func([]byte) error
+	MessageTimeout      time.Duration
+	MaxConcurrency      int
+	Middlewares         []Middleware
+
+	DefaultErrorClassification ErrorClassification
+	StrictFilter               bool
+	HeadersOnly                bool
+	RawPayloadEncoding         bool
+	InputJSONSchema            *jsonschema.Schema
+	OutputJSONSchema           *jsonschema.Schema
+	Stats                      bool
+	TracerProvider             trace.TracerProvider
+	Logger                     *slog.Logger
+	Serializer                 Serializer
+	InputsSchema               any
+	RequiredInputs             []string
+	InputsValidator            func(map[string]string) error
+	DryRun                     bool
+	Compression                bool
+	MaxDecompressedSize        int64
+	OutputCompression          string
+	OutputCompressionMinSize   int
+	Crypto                     Crypto
+	Init                       *initState
+	Shutdown                   *shutdownState
+	Resources                  map[string]any
+	RateLimiter                *rate.Limiter
+	FailureMetadata            bool
+	OnFailure                  FailurePolicy
+	OnDecodeFailure            *FailurePolicy
+	PassthroughErrorHeader     string
+	StandardHeaders            bool
+	Base64Variants             []Base64Variant
+	MaxPayloadSize             int
+	ClaimCheck                 PayloadFetcher
+	ClaimCheckThreshold        int
+	ProtoFiles                 *protoregistry.Files
+	SchemaRegistry             *schemaRegistry
+	ContentTypeRoutes          map[string]PayloadTypes
+	DetectFormats              []PayloadTypes
+	StrictJSON                 bool
+	JSONNumbers                bool
+	JSONEncoding               *JSONEncodeOptions
+	ErrorContextHeaders        []string
+	FailureThreshold           float64
+	CircuitBreaker             *circuitBreaker
+	RetryAttempts              int
+	RetryBackoff               BackoffFunc
+	RetryIf                    func(error) bool
+	DedupHeader                string
+	FailDuplicates             bool
+	DedupStore                 DedupStore
+	DedupStoreHeader           string
+	DedupStoreTTL              time.Duration
+	DedupStoreFailClosed       bool
+	PreFilter                  func(map[string]string) FilterDecision
+	OnMessageFailed            func(context.Context, MemphisMsg, error)
+	OnSuccess                  func(context.Context, MemphisMsg, MemphisMsg, time.Duration)
+	DeadlineMargin             time.Duration
+	FromEnv                    bool
+	MaxRequestBodySize         int64
+	PerMessageLogging          bool
+	MessageLogLevel            slog.Level
+	ConfigSchema               any
+	SecretSources              map[string]*secretSource
+	EMFNamespace               string
+	EMFDimensions              map[string]string
+	MetricsRecorder            MetricsRecorder
+	DebugSample                int
+	DebugSampleRedactHeaders   []string
+	MaxErrorSize               int
+	MaxTotalErrorsSize         int
+	MaxReportedErrors          int
+	UTF8Validation             UTF8Validation
+	HeaderNormalization        HeaderNormalization
+	HeaderAllowlist            map[string]struct{}
+	HeaderDenylist             map[string]struct{}
+	DefaultHeaders             map[string]string
+}
+
+type PayloadTypes int
+
+const (
+	BYTES PayloadTypes = iota + 1
+	// JSON payloads are decoded into the user schema, or without one into a fresh any holding a map[string]any, a []any or a scalar.
+	// With a json.RawMessage schema the handler gets the raw payload, once checked to be valid JSON.
+	JSON
+	PROTOBUF
+	AVRO
+	MSGPACK
+	CBOR
+	XML
+	YAML
+	TEXT
+	GOB
+	FLATBUFFERS
+)
+
+func PayloadInfo(schema any, schemaType PayloadTypes) PayloadOption {
+	if schemaType == GOB && schema != nil {
+		// registered once at startup so the schema type can also travel behind interface fields
+		gob.Register(schema)
+	}
+
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.UserObject = schema
+		payloadOptions.PayloadType = schemaType
+		return nil
+	}
+}
+
+// AvroSchema sets the writer schema used to decode and re-encode AVRO payloads.
+func AvroSchema(schemaJSON string) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		schema, err := avro.Parse(schemaJSON)
+		if err != nil {
+			return fmt.Errorf("couldn't parse avro schema: %w", err)
+		}
+		payloadOptions.AvroSchema = schema
+		return nil
+	}
+}
+
+// WithMessageTimeout bounds every handler call to d, messages whose handler doesn't return in time are considered failed.
+// The handler gets a context that is canceled once d expires so it can abort its own work.
+func WithMessageTimeout(d time.Duration) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if d < 0 {
+			return fmt.Errorf("message timeout can't be negative: %v", d)
+		}
+		payloadOptions.MessageTimeout = d
+		return nil
+	}
+}
+
+// WithMaxConcurrency processes up to n messages of an event at the same time instead of one after the other.
+// The handler must then be safe for concurrent use, the output keeps the order of the incoming messages.
+func WithMaxConcurrency(n int) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if n < 1 {
+			return fmt.Errorf("max concurrency must be at least 1, got %d", n)
+		}
+		payloadOptions.MaxConcurrency = n
+		return nil
+	}
+}
+
+// WithMiddleware wraps the handler with the middlewares, the first one being the outermost.
+func WithMiddleware(middlewares ...Middleware) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.Middlewares = append(payloadOptions.Middlewares, middlewares...)
+		return nil
+	}
+}
+
+// WithDefaultErrorClassification sets the classification of failed messages whose error wasn't wrapped with Retryable or Permanent.
+func WithDefaultErrorClassification(classification ErrorClassification) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.DefaultErrorClassification = classification
+		return nil
+	}
+}
+
+// WithStrictFilter only filters messages whose handler returned ErrFilterMessage,
+// a handler returning nil payload, headers and error then fails the message instead of silently dropping it.
+func WithStrictFilter() PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.StrictFilter = true
+		return nil
+	}
+}
+
+// WithHeadersOnly skips decoding and re-encoding the payload for handlers that only modify headers.
+// The handler gets a nil payload and must return a nil payload, the original payload is emitted as is.
+func WithHeadersOnly() PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.HeadersOnly = true
+		return nil
+	}
+}
+
+// WithRawPayloadEncoding reads the payloads of the event as plain strings instead of base64,
+// and emits the payloads of the output the same way. The default base64 encoding is used for both otherwise.
+func WithRawPayloadEncoding() PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.RawPayloadEncoding = true
+		return nil
+	}
+}
+
+// WithInputJSONSchema validates every payload against the JSON schema before it is decoded,
+// payloads violating it are failed without calling the handler. The schema is compiled once, when the option is created.
+func WithInputJSONSchema(schema string) PayloadOption {
+	compiled, err := compileJSONSchema(schema)
+	return func(payloadOptions *PayloadOptions) error {
+		if err != nil {
+			return fmt.Errorf("couldn't compile input JSON schema: %w", err)
+		}
+		payloadOptions.InputJSONSchema = compiled
+		return nil
+	}
+}
+
+// WithOutputJSONSchema validates every marshaled payload returned by the handler against the JSON schema before it is emitted,
+// payloads violating it fail the message. The schema is compiled once, when the option is created.
+func WithOutputJSONSchema(schema string) PayloadOption {
+	compiled, err := compileJSONSchema(schema)
+	return func(payloadOptions *PayloadOptions) error {
+		if err != nil {
+			return fmt.Errorf("couldn't compile output JSON schema: %w", err)
+		}
+		payloadOptions.OutputJSONSchema = compiled
+		return nil
+	}
+}
+
+// DryRunInput is the input that enables the dry-run mode for an invocation, like WithDryRun(true).
+const DryRunInput = "__dry_run"
+
+// WithDryRun processes every message as usual and reports what would have happened in the Stats and logs,
+// but emits the original messages untouched and never sends failed messages to the dead-letter station.
+func WithDryRun(dryRun bool) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.DryRun = dryRun
+		return nil
+	}
+}
+
+// isDryRun tells whether the invocation runs in dry-run mode, through the option or the DryRunInput.
+func (payloadOptions *PayloadOptions) isDryRun(inputs map[string]string) (bool, error) {
+	value, ok := inputs[DryRunInput]
+	if !ok {
+		return payloadOptions.DryRun, nil
+	}
+
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s input: %w", DryRunInput, err)
+	}
+	return dryRun, nil
+}
+
+// validate checks that the user schema can be used with the chosen PayloadType, and picks its Serializer.
+func (payloadOptions *PayloadOptions) validate() error {
+	if payloadOptions.PayloadType == PROTOBUF && payloadOptions.UserObject != nil {
+		switch payloadOptions.UserObject.(type) {
+		case proto.Message, protoreflect.MessageDescriptor:
+		default:
+			return fmt.Errorf("schema of type %v doesn't implement proto.Message", reflect.TypeOf(payloadOptions.UserObject))
+		}
+	}
+
+	if payloadOptions.PayloadType == AVRO && payloadOptions.AvroSchema == nil {
+		return fmt.Errorf("the AVRO payload type requires an AvroSchema option")
+	}
+
+	if payloadOptions.HeaderAllowlist != nil && payloadOptions.HeaderDenylist != nil {
+		return errHeaderAllowAndDenylist
+	}
+
+	if payloadOptions.Serializer == nil {
+		payloadOptions.Serializer = defaultSerializer(payloadOptions)
+	}
+
+	return nil
+}
+
+func UnmarshalIntoStruct(data []byte, userStruct any) error {
+	// Unmarshal JSON data into the struct
+	err := json.Unmarshal(data, userStruct)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// isRawJSONSchema tells whether schema asks for JSON payloads to be passed to the handler as json.RawMessage.
+func isRawJSONSchema(schema any) bool {
+	switch schema.(type) {
+	case json.RawMessage, *json.RawMessage:
+		return true
+	}
+	return false
+}
+
+// newUserObject allocates a fresh instance of the type pointed to by schema so that
+// every message is unmarshaled into its own object instead of sharing the schema.
+// Without a schema a generic map is used, which AVRO records can always be decoded into.
+// A proto message descriptor gets a dynamic message, which keeps unknown fields like generated ones do.
+func newUserObject(schema any) any {
+	if schema == nil {
+		return new(map[string]any)
+	}
+	if descriptor, ok := schema.(protoreflect.MessageDescriptor); ok {
+		return dynamicpb.NewMessage(descriptor)
+	}
+
+	schemaType := reflect.TypeOf(schema)
+	if schemaType.Kind() == reflect.Pointer {
+		schemaType = schemaType.Elem()
+	}
+
+	return reflect.New(schemaType).Interface()
+}
+
+// BuildHandler returns the function NewHandler wraps into a lambda.Handler, so events can be processed
+// without the Lambda runtime, e.g. in tests or when running locally.
+func BuildHandler(eventHandler HandlerType, options ...PayloadOption) func(context.Context, *MemphisEvent) (*MemphisOutput, error) {
+	return BuildHandlerWithContext(withoutContext(eventHandler), options...)
+}
+
+// Function is a handler built with its options, which are applied once when it is created.
+// It is what the entry points run, and lets other platforms' adapters run a function the same way.
+type Function struct {
+	invoke func(context.Context, *MemphisEvent) (*MemphisOutput, error)
+	// config is nil when the options are invalid, every invocation then fails with their error
+	config *PayloadOptions
+}
+
+// NewFunction builds the function processing events with eventHandler, like BuildHandler.
+func NewFunction(eventHandler HandlerType, options ...PayloadOption) *Function {
+	return newFunction(withoutContext(eventHandler), options)
+}
+
+// Invoke processes event.
+func (function *Function) Invoke(ctx context.Context, event *MemphisEvent) (*MemphisOutput, error) {
+	return function.invoke(ctx, event)
+}
+
+// logger returns the logger of the function, the default one without WithLogger.
+func (function *Function) logger() *slog.Logger {
+	if function.config == nil || function.config.Logger == nil {
+		return slog.Default()
+	}
+	return function.config.Logger
+}
+
+// callHandler runs the user handler for a single message, bounded by the MessageTimeout if one is set.
+func (payloadOptions *PayloadOptions) callHandler(ctx context.Context, message any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+	if payloadOptions.MessageTimeout == 0 && ctx.Done() == nil {
+		return payloadOptions.runHandler(ctx, message, headers, inputs)
+	}
+
+	invocationCtx := ctx
+	if payloadOptions.MessageTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, payloadOptions.MessageTimeout)
+		defer cancel()
+	}
+
+	type handlerResult struct {
+		payload any
+		headers map[string]string
+		err     error
+	}
+	done := make(chan handlerResult, 1) // buffered so an abandoned handler doesn't leak blocked forever
+	go func() {
+		modifiedPayload, modifiedHeaders, err := payloadOptions.runHandler(ctx, message, headers, inputs)
+		done <- handlerResult{modifiedPayload, modifiedHeaders, err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.payload, result.headers, result.err
+	case <-ctx.Done():
+		// the invocation being done aborts the handler too, without waiting for it to return
+		if err := invocationCtx.Err(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("handler timeout after %v", payloadOptions.MessageTimeout)
+	}
+}
+
+// runHandler calls the user handler and turns a panic into an error so the rest of the batch is still processed.
+func (payloadOptions *PayloadOptions) runHandler(ctx context.Context, message any, headers map[string]string, inputs map[string]string) (modifiedPayload any, modifiedHeaders map[string]string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			modifiedPayload, modifiedHeaders = nil, nil
+			err = fmt.Errorf("handler panicked: %v\n%s", r, panicStack())
+		}
+	}()
+
+	return payloadOptions.Handler(ctx, message, headers, inputs)
+}
+
+// maxPanicStackLines caps the stack trace kept in the error of a panicking message.
+const maxPanicStackLines = 20
+
+// panicStack returns the stack of a recovered panic starting at the panicking frame.
+func panicStack() string {
+	lines := strings.Split(strings.TrimSpace(string(debug.Stack())), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "panic(") {
+			lines = lines[i+2:] // skip the panic call and its file:line
+			break
+		}
+	}
+
+	if len(lines) > maxPanicStackLines {
+		lines = lines[:maxPanicStackLines]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// withoutContext adapts a HandlerType to the HandlerWithContextType used internally.
+func withoutContext(eventHandler HandlerType) HandlerWithContextType {
+	return func(_ context.Context, message any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		return eventHandler(message, headers, inputs)
+	}
+}
+
+// BuildHandlerWithContext is BuildHandler for handlers that get the invocation's context.
+func BuildHandlerWithContext(eventHandler HandlerWithContextType, options ...PayloadOption) func(context.Context, *MemphisEvent) (*MemphisOutput, error) {
+	return newFunction(eventHandler, options).Invoke
+}
+
+func newFunction(eventHandler HandlerWithContextType, options []PayloadOption) *Function {
+	config, err := newPayloadOptions(eventHandler, options)
+	if err != nil {
+		return invalidOptionsFunction(err)
+	}
+	// composed once for the whole function, not for every message
+	for i := len(config.Middlewares) - 1; i >= 0; i-- {
+		config.Handler = config.Middlewares[i](config.Handler)
+	}
+
+	invoke := func(ctx context.Context, event *MemphisEvent) (*MemphisOutput, error) {
+		start := time.Now()
+		params := config.forInvocation()
+		endInvocation, err := params.beginInvocation()
+		if err != nil {
+			return nil, err
+		}
+		defer endInvocation()
+
+		inputs, err := params.resolveSecrets(ctx, event.Inputs)
+		if err != nil {
+			return nil, err
+		}
+		event = &MemphisEvent{Inputs: inputs, Messages: event.Messages}
+
+		if ctx, err = params.withInputs(ctx, event.Inputs); err != nil {
+			return nil, err
+		}
+
+		if err := params.resolveProtoMessage(event.Inputs); err != nil {
+			return nil, err
+		}
+
+		if ctx, err = params.initialize(ctx, event.Inputs); err != nil {
+			return nil, err
+		}
+
+		dryRun, err := params.isDryRun(event.Inputs)
+		if err != nil {
+			return nil, err
+		}
+
+		debugSample, err := params.debugSampleSize(event.Inputs)
+		if err != nil {
+			return nil, err
+		}
+		params.logDebugSample(ctx, event, debugSample)
+
+		// results are assembled only once all of them are collected, in the order of event.Messages
+		var processedEvent MemphisOutput
+		results := params.processMessages(ctx, event)
+		if dryRun {
+			processedEvent.Messages = append(processedEvent.Messages, event.Messages...)
+			params.logDryRunFailures(ctx, results)
+		} else {
+			if err := params.checkFailureThreshold(results); err != nil {
+				return nil, err
+			}
+			for i, result := range results {
+				processedEvent.Messages = append(processedEvent.Messages, result.messages...)
+				if result.outcome() == unprocessedOutcome {
+					processedEvent.UnprocessedMessages = append(processedEvent.UnprocessedMessages, event.Messages[i])
+					continue
+				}
+				if result.failedMessage == nil {
+					params.notifySuccess(ctx, event.Messages[i], result)
+					continue
+				}
+				switch params.failurePolicy(result.failedMessage.Category) {
+				case DropFailures:
+					params.logFailure(ctx, "message failed and was dropped", i, result.failedMessage)
+				case PassthroughOriginal:
+					params.logFailure(ctx, "message failed and was passed through", i, result.failedMessage)
+					processedEvent.Messages = append(processedEvent.Messages, params.passthroughMessage(result.failedMessage))
+				default:
+					processedEvent.FailedMessages = append(processedEvent.FailedMessages, *result.failedMessage)
+					params.notifyFailure(ctx, event.Messages[i], result.failedMessage, result.err)
+				}
+			}
+			if params.DedupStore != nil {
+				params.markProcessed(ctx, results)
+			}
+			params.limitErrors(processedEvent.FailedMessages)
+		}
+
+		if params.Stats {
+			processedEvent.Stats = newStats(results)
+		}
+		params.logInvocation(ctx, results, time.Since(start))
+		params.writeEMFMetrics(results)
+
+		return &processedEvent, nil
+	}
+	return &Function{invoke: invoke, config: config}
+}
+
+// newPayloadOptions applies the options on top of the defaults and validates the result.
+func newPayloadOptions(eventHandler HandlerWithContextType, options []PayloadOption) (*PayloadOptions, error) {
+	params := PayloadOptions{
+		Handler:            eventHandler,
+		UserObject:         nil,
+		PayloadType:        BYTES,
+		PerMessageLogging:  true,
+		MessageLogLevel:    slog.LevelDebug,
+		MetricsRecorder:    noopMetricsRecorder{},
+		MaxErrorSize:       DefaultMaxErrorSize,
+		MaxTotalErrorsSize: DefaultMaxTotalErrorsSize,
+		MaxReportedErrors:  DefaultMaxReportedErrors,
+	}
+	defaults := params
+
+	if err := params.apply(options); err != nil {
+		return nil, err
+	}
+	if params.FromEnv {
+		// the options are applied again on top of the environment ones, so they take precedence
+		envOptions, err := envOptions()
+		if err != nil {
+			return nil, err
+		}
+		params = defaults
+		if err := params.apply(append(envOptions, options...)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+
+	return &params, nil
+}
+
+// forInvocation returns the options of a single invocation, a copy of the ones applied when the function was built
+// with their per-invocation state reset, so the invocation can't alter the options the next ones start from.
+func (payloadOptions *PayloadOptions) forInvocation() *PayloadOptions {
+	params := *payloadOptions
+	if params.CircuitBreaker != nil {
+		params.CircuitBreaker = &circuitBreaker{threshold: params.CircuitBreaker.threshold}
+	}
+	return &params
+}
+
+// invalidOptionsFunction logs err, the error of the options a function was built with, and fails every invocation with it.
+func invalidOptionsFunction(err error) *Function {
+	slog.Error("invalid function options", "error", err)
+	return &Function{invoke: func(context.Context, *MemphisEvent) (*MemphisOutput, error) {
+		return nil, err
+	}}
+}
+
+// apply applies the options in order, skipping nil ones.
+func (payloadOptions *PayloadOptions) apply(options []PayloadOption) error {
+	for _, option := range options {
+		if option != nil {
+			if err := option(payloadOptions); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// withInputs checks the inputs of the event, and adds them to ctx decoded into the WithInputs struct and the WithConfig one if set.
+func (payloadOptions *PayloadOptions) withInputs(ctx context.Context, inputs map[string]string) (context.Context, error) {
+	if err := payloadOptions.checkInputs(inputs); err != nil {
+		return nil, err
+	}
+
+	if payloadOptions.InputsSchema != nil {
+		typedInputs, err := decodeInputs(inputs, payloadOptions.InputsSchema)
+		if err != nil {
+			return nil, err
+		}
+		ctx = context.WithValue(ctx, inputsContextKey{}, typedInputs)
+	}
+
+	return payloadOptions.withConfig(ctx, inputs)
+}
+
+// TypedHandlerType functions get the message payload unmarshaled into a fresh *T, message headers as map[string]string and inputs as map[string]string and should return the modified payload and headers.
+// error should be returned if the message should be considered failed and go into the dead-letter station.
+// if all returned values are nil the message will be filtered out of the station.
+type TypedHandlerType[T any] func(*T, map[string]string, map[string]string) (*T, map[string]string, error)
+
+// messageResult is the outcome of processing a single message, a filtered message has neither field set.
+type messageResult struct {
+	messages      []MemphisMsg
+	failedMessage *MemphisMsgWithError
+	err           error // the error of the failed message
+	duplicate     bool  // suppressed by WithDedupHeader or WithDedupStore
+	dedupKey      string
+
+	decodedSize     int
+	handlerDuration time.Duration
+	encodeDuration  time.Duration
+	strippedHeaders int
+	duration        time.Duration
+}
+
+// Outcomes of a message, as reported by messageResult.outcome.
+const (
+	emittedOutcome     = "emitted"
+	filteredOutcome    = "filtered"
+	failedOutcome      = "failed"
+	unprocessedOutcome = "unprocessed"
+)
+
+func (result messageResult) outcome() string {
+	switch {
+	case result.failedMessage != nil && result.failedMessage.Category == NotProcessedErrorCategory:
+		return unprocessedOutcome
+	case result.failedMessage != nil:
+		return failedOutcome
+	case len(result.messages) == 0:
+		return filteredOutcome
+	default:
+		return emittedOutcome
+	}
+}
+
+func (payloadOptions *PayloadOptions) failedResult(msg MemphisMsg, category string, err error) messageResult {
+	return messageResult{
+		failedMessage: newMsgWithError(msg, category, err, payloadOptions.DefaultErrorClassification),
+		err:           err,
+	}
+}
+
+// processMessages processes the messages of the event, using up to MaxConcurrency workers.
+// The results are addressed by the index of their message so the output keeps the order of the event.
+func (payloadOptions *PayloadOptions) processMessages(ctx context.Context, event *MemphisEvent) []messageResult {
+	results := make([]messageResult, len(event.Messages))
+	// duplicates are found upfront so the first occurrence is the one kept, whatever the order the workers run in
+	duplicates := payloadOptions.findDuplicates(event.Messages)
+	isDuplicate := func(i int) bool { return duplicates != nil && duplicates[i] }
+	if payloadOptions.MaxConcurrency <= 1 {
+		for i := range event.Messages {
+			results[i] = payloadOptions.timedProcessMessage(ctx, i, event.Messages[i], event.Inputs, isDuplicate(i))
+		}
+		return results
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(payloadOptions.MaxConcurrency, len(event.Messages)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = payloadOptions.timedProcessMessage(ctx, i, event.Messages[i], event.Inputs, isDuplicate(i))
+			}
+		}()
+	}
+
+	for i := range event.Messages {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+// timedProcessMessage processes msg, the message at index of the event, and records how long it took.
+// A duplicate msg is suppressed without being processed.
+func (payloadOptions *PayloadOptions) timedProcessMessage(ctx context.Context, index int, msg MemphisMsg, inputs map[string]string, duplicate bool) messageResult {
+	logger := payloadOptions.messageLogger(ctx, index)
+	if logger != nil {
+		ctx = context.WithValue(ctx, loggerContextKey{}, logger)
+	}
+
+	ctx, span := payloadOptions.startMessageSpan(ctx, msg)
+	start := time.Now()
+	var result messageResult
+	if duplicate {
+		result = payloadOptions.duplicateResult(msg, payloadOptions.DedupHeader)
+	} else {
+		result = payloadOptions.processMessage(ctx, msg, inputs)
+	}
+	result.duration = time.Since(start)
+	if result.failedMessage != nil {
+		result.err = payloadOptions.newMessageError(index, msg, result.err)
+		result.failedMessage.Error = result.err.Error()
+	}
+	if payloadOptions.FailureMetadata && result.failedMessage != nil {
+		result.failedMessage.Metadata = newFailureMetadata(msg, start, result.handlerDuration)
+	}
+	endMessageSpan(span, result)
+	payloadOptions.logMessageResult(ctx, logger, result)
+	payloadOptions.recordMetrics(result)
+	return result
+}
+
+// processMessage decodes a single message, passes it to the handler and encodes the handler's result.
+func (payloadOptions *PayloadOptions) processMessage(ctx context.Context, msg MemphisMsg, inputs map[string]string) (result messageResult) {
+	switch payloadOptions.preFilter(msg) {
+	case Filter:
+		return messageResult{}
+	case Passthrough:
+		return messageResult{messages: []MemphisMsg{msg}}
+	}
+	if err := payloadOptions.checkDeadline(ctx); err != nil {
+		return payloadOptions.failedResult(msg, NotProcessedErrorCategory, err)
+	}
+
+	dedupKey, seen, err := payloadOptions.checkDedupStore(ctx, msg)
+	if err != nil {
+		return payloadOptions.failedResult(msg, NotProcessedErrorCategory, err)
+	}
+	if seen {
+		return payloadOptions.duplicateResult(msg, payloadOptions.DedupStoreHeader)
+	}
+	defer func() { result.dedupKey = dedupKey }()
+
+	// input is the message as the handler sees it, failed messages always keep the original msg
+	input, handlerInput, decodedSize, category, err := payloadOptions.decodeMessage(ctx, msg)
+	defer func() { result.decodedSize = decodedSize }()
+	if err != nil {
+		return payloadOptions.failedResult(msg, category, err)
+	}
+
+	if payloadOptions.CircuitBreaker.open() {
+		return payloadOptions.failedResult(msg, NotProcessedErrorCategory, errCircuitOpen)
+	}
+	if err := payloadOptions.waitRateLimit(ctx); err != nil {
+		return payloadOptions.failedResult(msg, NotProcessedErrorCategory, err)
+	}
+
+	handlerStart := time.Now()
+	// the handler gets its own copy of the headers, so one mutating them before failing doesn't alter the failed message
+	modifiedPayload, modifiedHeaders, err := payloadOptions.callHandlerWithRetry(ctx, handlerInput, input.Headers, inputs)
+	handlerDuration := time.Since(handlerStart)
+	defer func() { result.handlerDuration = handlerDuration }()
+	if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		// the handler was aborted because the invocation is done, the message wasn't really processed
+		return payloadOptions.failedResult(msg, NotProcessedErrorCategory, Retryable(fmt.Errorf("not processed: %w", err)))
+	}
+	payloadOptions.CircuitBreaker.record(err != nil && !errors.Is(err, ErrFilterMessage))
+
+	if errors.Is(err, ErrFilterMessage) {
+		return messageResult{} // filtered out of the station
+	}
+	if err != nil {
+		return payloadOptions.failedResult(msg, HandlerErrorCategory, err)
+	}
+	if payloadOptions.HeadersOnly && modifiedPayload != nil {
+		return payloadOptions.failedResult(msg, HandlerErrorCategory, fmt.Errorf("handlers in headers only mode must return a nil payload"))
+	}
+	if modifiedPayload == nil && modifiedHeaders == nil {
+		if payloadOptions.StrictFilter {
+			return payloadOptions.failedResult(msg, HandlerErrorCategory, fmt.Errorf("handler returned nil payload and headers without ErrFilterMessage"))
+		}
+		return messageResult{} // filtered out of the station
+	}
+
+	outMsgs, ok := modifiedPayload.([]OutMsg)
+	if !ok {
+		outMsgs = []OutMsg{{Payload: modifiedPayload, Headers: modifiedHeaders}}
+	}
+
+	encodeStart := time.Now()
+	defer func() { result.encodeDuration = time.Since(encodeStart) }()
+	for _, outMsg := range outMsgs {
+		if outMsg.Headers == nil {
+			outMsg.Headers = modifiedHeaders
+		}
+		outputMsg, stripped, category, err := payloadOptions.encodeOutput(ctx, input, outMsg)
+		if err != nil {
+			return payloadOptions.failedResult(msg, category, err)
+		}
+		result.strippedHeaders += stripped
+		result.messages = append(result.messages, outputMsg)
+	}
+
+	return result // an empty []OutMsg filters the message
+}
+
+// decodeMessage decrypts, decompresses, validates and decodes the payload of msg.
+// It returns the message as the handler sees it, the decoded input of the handler and the size of the decoded payload.
+// On failure the category of the error is returned along with it.
+func (payloadOptions *PayloadOptions) decodeMessage(ctx context.Context, msg MemphisMsg) (MemphisMsg, any, int, string, error) {
+	input := msg
+	input.Headers = payloadOptions.normalizeHeaders(msg.Headers)
+	if payloadOptions.HeadersOnly {
+		return input, nil, 0, "", nil
+	}
+
+	if err := payloadOptions.checkPayloadSize(msg.Payload); err != nil {
+		return input, nil, 0, PayloadSizeErrorCategory, err
+	}
+
+	payload, err := payloadOptions.decodePayload(msg.Payload)
+	if err != nil {
+		return input, nil, 0, DecodeErrorCategory, fmt.Errorf("couldn't decode message: %w", err)
+	}
+
+	if payloadOptions.ClaimCheck != nil {
+		var headers map[string]string
+		if payload, headers, err = payloadOptions.fetchClaimCheck(ctx, payload, input.Headers); err != nil {
+			return input, nil, 0, ClaimCheckErrorCategory, err
+		}
+		if payloadOptions.MaxPayloadSize > 0 && len(payload) > payloadOptions.MaxPayloadSize {
+			return input, nil, 0, PayloadSizeErrorCategory, fmt.Errorf("payload %d bytes exceeds limit %d", len(payload), payloadOptions.MaxPayloadSize)
+		}
+		input = MemphisMsg{Headers: headers, Payload: payloadOptions.encodePayload(payload)}
+	}
+
+	if payloadOptions.Crypto != nil {
+		if payload, err = payloadOptions.Crypto.Decrypt(ctx, payload, input.Headers); err != nil {
+			return input, nil, 0, DecryptErrorCategory, fmt.Errorf("couldn't decrypt message: %w", err)
+		}
+		input.Payload = payloadOptions.encodePayload(payload)
+	}
+
+	if payloadOptions.Compression {
+		var headers map[string]string
+		if payload, headers, err = payloadOptions.decompress(payload, input.Headers); err != nil {
+			return input, nil, 0, DecompressErrorCategory, fmt.Errorf("couldn't decompress message: %w", err)
+		}
+		input = MemphisMsg{Headers: headers, Payload: payloadOptions.encodePayload(payload)}
+	}
+
+	if payloadOptions.InputJSONSchema != nil {
+		if err := validateJSONSchema(payloadOptions.InputJSONSchema, payload); err != nil {
+			return input, nil, len(payload), InputSchemaErrorCategory, fmt.Errorf("input schema violation: %w", err)
+		}
+	}
+
+	serializer, err := payloadOptions.registrySerializer(ctx, input.Headers)
+	if err != nil {
+		return input, nil, len(payload), SchemaRegistryErrorCategory, err
+	}
+	if serializer != nil {
+		userObject := newUserObject(payloadOptions.UserObject)
+		if err := serializer.Unmarshal(payload, userObject); err != nil {
+			return input, nil, len(payload), DecodeErrorCategory, fmt.Errorf("couldn't unmarshal message with schema %s: %w", Headers(input.Headers).Get(SchemaIDHeader), err)
+		}
+		return input, userObject, len(payload), "", nil
+	}
+
+	if payloadOptions.DetectFormats != nil {
+		format, err := payloadOptions.detectFormat(payload)
+		if err != nil {
+			return input, nil, len(payload), DecodeErrorCategory, err
+		}
+		input.Headers = copyHeaders(input.Headers)
+		if input.Headers == nil {
+			input.Headers = make(map[string]string, 1)
+		}
+		input.Headers[PayloadFormatHeader] = format.String()
+	}
+
+	routedOptions, err := payloadOptions.routed(input.Headers)
+	if err != nil {
+		return input, nil, len(payload), DecodeErrorCategory, err
+	}
+	validPayload, err := routedOptions.validateUTF8(payload)
+	if err != nil {
+		return input, nil, len(payload), InvalidUTF8ErrorCategory, err
+	}
+	payload = validPayload
+	handlerInput, err := routedOptions.decodeInput(payload)
+	if err != nil {
+		return input, nil, len(payload), DecodeErrorCategory, err
+	}
+
+	return input, handlerInput, len(payload), "", nil
+}
+
+// decodeInput decodes the payload into the input of the handler according to the PayloadType.
+func (payloadOptions *PayloadOptions) decodeInput(payload []byte) (any, error) {
+	switch {
+	case payloadOptions.PayloadType == TEXT:
+		var text string
+		if err := (textSerializer{}).Unmarshal(payload, &text); err != nil {
+			return nil, fmt.Errorf("couldn't decode message: %w", err)
+		}
+		return text, nil
+	case payloadOptions.PayloadType == FLATBUFFERS:
+		err := verifyFlatbuffer(payload)
+		if err == nil && payloadOptions.FlatbuffersVerifier != nil {
+			err = payloadOptions.FlatbuffersVerifier(payload)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't verify flatbuffer: %w", err)
+		}
+		return payload, nil // passed as is, without copying
+	case payloadOptions.PayloadType == JSON && isRawJSONSchema(payloadOptions.UserObject):
+		if !json.Valid(payload) {
+			return nil, fmt.Errorf("couldn't decode message: payload is not valid JSON")
+		}
+		return json.RawMessage(payload), nil
+	case payloadOptions.PayloadType == JSON && payloadOptions.UserObject == nil:
+		// without a schema JSON values are handed over generically rather than as raw bytes:
+		// objects as map[string]any, arrays as []any and scalars as string, float64, bool or nil
+		var value any
+		if err := payloadOptions.Serializer.Unmarshal(payload, &value); err != nil {
+			return nil, fmt.Errorf("couldn't unmarshal JSON message: %w", err)
+		}
+		return value, nil
+	case payloadOptions.UserObject != nil || payloadOptions.PayloadType == AVRO:
+		// every message (and every worker) gets its own object
+		userObject := newUserObject(payloadOptions.UserObject)
+		if err := payloadOptions.Serializer.Unmarshal(payload, userObject); err != nil {
+			return nil, fmt.Errorf("couldn't unmarshal message into user schema: %w", err)
+		}
+		return userObject, nil
+	}
+
+	return payload, nil
+}
+
+// decodePayload returns the bytes of a payload as it appears in the event, base64 unless the raw payload encoding is used.
+func (payloadOptions *PayloadOptions) decodePayload(payload string) ([]byte, error) {
+	if payloadOptions.RawPayloadEncoding {
+		return []byte(payload), nil
+	}
+	return payloadOptions.decodeBase64(payload)
+}
+
+// WithMaxPayloadSize fails the messages whose payload is larger than maxBytes once decoded, without decoding them.
+// The limit applies to the payload as it is received, before it is decrypted or decompressed. Zero means unlimited.
+func WithMaxPayloadSize(maxBytes int) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if maxBytes < 0 {
+			return fmt.Errorf("max payload size must not be negative, got %d", maxBytes)
+		}
+		payloadOptions.MaxPayloadSize = maxBytes
+		return nil
+	}
+}
+
+// checkPayloadSize fails when the decoded payload would exceed the MaxPayloadSize, its size is computed from the encoded one.
+func (payloadOptions *PayloadOptions) checkPayloadSize(payload string) error {
+	if payloadOptions.MaxPayloadSize == 0 {
+		return nil
+	}
+
+	size := len(payload)
+	if !payloadOptions.RawPayloadEncoding {
+		size = len(strings.TrimRight(payload, "=")) * 6 / 8
+	}
+	if size > payloadOptions.MaxPayloadSize {
+		return fmt.Errorf("payload %d bytes exceeds limit %d", size, payloadOptions.MaxPayloadSize)
+	}
+
+	return nil
+}
+
+// encodePayload is the inverse of decodePayload for the payloads of the output.
+func (payloadOptions *PayloadOptions) encodePayload(payload []byte) string {
+	if payloadOptions.RawPayloadEncoding {
+		return string(payload)
+	}
+	return base64.StdEncoding.EncodeToString(payload)
+}
+
+// encodeOutput marshals and encodes a payload returned by the handler,
+// a nil payload or nil headers keep the ones of the input message.
+// It also returns how many headers the allowlist or the denylist stripped. On failure the category of the error is returned along with it.
+func (payloadOptions *PayloadOptions) encodeOutput(ctx context.Context, input MemphisMsg, outMsg OutMsg) (MemphisMsg, int, string, error) {
+	outputMsg := MemphisMsg{
+		Headers: outMsg.Headers,
+		Payload: input.Payload,
+	}
+	if outputMsg.Headers == nil {
+		outputMsg.Headers = input.Headers
+	}
+	if format, ok := Headers(input.Headers).lookup(PayloadFormatHeader); ok && payloadOptions.DetectFormats != nil && outputMsg.Headers[PayloadFormatHeader] != format {
+		outputMsg.Headers = copyHeaders(outputMsg.Headers)
+		if outputMsg.Headers == nil {
+			outputMsg.Headers = make(map[string]string, 1)
+		}
+		outputMsg.Headers[PayloadFormatHeader] = format
+	}
+	// handler headers take precedence over the standard headers, which take precedence over the default ones
+	if payloadOptions.StandardHeaders {
+		outputMsg.Headers = standardHeaders(ctx, outputMsg.Headers)
+	}
+	if len(payloadOptions.DefaultHeaders) > 0 {
+		outputMsg.Headers = payloadOptions.defaultHeaders(outputMsg.Headers)
+	}
+	outputMsg.Headers = payloadOptions.normalizeHeaders(outputMsg.Headers)
+	outputMsg.Destination = Headers(outputMsg.Headers).Get(DestinationHeader)
+	// filtered before the compression, encryption and claim check add their headers, which consumers need to read the payload
+	var stripped int
+	outputMsg.Headers, stripped = payloadOptions.filterHeaders(outputMsg.Headers)
+
+	var payload []byte
+	switch {
+	case outMsg.Payload == nil && payloadOptions.Crypto == nil && payloadOptions.ClaimCheck == nil:
+		return outputMsg, stripped, "", nil
+	case outMsg.Payload == nil:
+		// the input payload was decrypted or fetched for the handler, so it's encrypted or stored again before being emitted
+		var err error
+		if payload, err = payloadOptions.decodePayload(input.Payload); err != nil {
+			return MemphisMsg{}, 0, MarshalErrorCategory, err
+		}
+	default:
+		switch outPayload := outMsg.Payload.(type) {
+		case []byte:
+			payload = outPayload
+		case json.RawMessage:
+			payload = outPayload // emitted verbatim, marshaling it would compact it
+		default:
+			// outputs are marshaled with the registry schema of the input message if it has one
+			serializer, err := payloadOptions.registrySerializer(ctx, input.Headers)
+			if err != nil {
+				return MemphisMsg{}, 0, SchemaRegistryErrorCategory, err
+			}
+			if serializer == nil {
+				routedOptions, err := payloadOptions.routed(input.Headers)
+				if err != nil {
+					return MemphisMsg{}, 0, MarshalErrorCategory, err
+				}
+				serializer = routedOptions.Serializer
+			}
+			if payload, err = serializer.Marshal(outMsg.Payload); err != nil {
+				return MemphisMsg{}, 0, MarshalErrorCategory, err
+			}
+		}
+
+		if payloadOptions.OutputJSONSchema != nil {
+			if err := validateJSONSchema(payloadOptions.OutputJSONSchema, payload); err != nil {
+				return MemphisMsg{}, 0, OutputSchemaErrorCategory, fmt.Errorf("output schema violation: %w", err)
+			}
+		}
+	}
+
+	payload, headers, err := payloadOptions.compressOutput(payload, outputMsg.Headers)
+	if err != nil {
+		return MemphisMsg{}, 0, MarshalErrorCategory, fmt.Errorf("couldn't compress output: %w", err)
+	}
+	if payloadOptions.Crypto != nil {
+		if payload, headers, err = payloadOptions.Crypto.Encrypt(ctx, payload, headers); err != nil {
+			return MemphisMsg{}, 0, MarshalErrorCategory, fmt.Errorf("couldn't encrypt output: %w", err)
+		}
+	}
+	if payload, headers, err = payloadOptions.storeClaimCheck(ctx, payload, headers); err != nil {
+		return MemphisMsg{}, 0, ClaimCheckErrorCategory, err
+	}
+	outputMsg.Headers = headers
+	outputMsg.Payload = payloadOptions.encodePayload(payload)
+
+	return outputMsg, stripped, "", nil
+}
+
+// userSchema sets the user schema while keeping the PayloadType chosen by the other options.
+func userSchema(schema any) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.UserObject = schema
+		return nil
+	}
+}
+
+func typedHandler[T any](eventHandler TypedHandlerType[T]) HandlerType {
+	return func(message any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		typedMessage, ok := message.(*T)
+		if !ok {
+			return nil, nil, fmt.Errorf("object failed type assertion: %v, %v", message, reflect.TypeOf(message))
+		}
+
+		modifiedMessage, modifiedHeaders, err := eventHandler(typedMessage, headers, inputs)
+		if modifiedMessage == nil {
+			// a nil *T stored in an any is not nil, so return an untyped nil to keep the filter semantics
+			return nil, modifiedHeaders, err
+		}
+
+		return modifiedMessage, modifiedHeaders, err
+	}
+}
@@ -0,0 +1,397 @@
+package functions_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"go_template/functions"
+	"go_template/memphistest"
+)
+
+type record struct {
+	ID   int    `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+func echo(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+	return payload, headers, nil
+}
+
+func TestUserObjectPerMessage(t *testing.T) {
+	var received []*record
+	handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		received = append(received, payload.(*record))
+		return payload, headers, nil
+	}, functions.PayloadInfo(&record{}, functions.JSON))
+	event := memphistest.NewEvent().
+		AddJSONMessage(map[string]any{"id": 1, "name": "first"}, nil).
+		AddJSONMessage(map[string]any{"id": 2}, nil).
+		Build(t)
+
+	out, err := handler(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 || received[0] == received[1] {
+		t.Fatalf("the messages share their user object: %v", received)
+	}
+	memphistest.RequireEmitted(t, out, 0, &record{ID: 1, Name: "first"})
+	memphistest.RequireEmitted(t, out, 1, &record{ID: 2})
+}
+
+func TestHandlerNilResults(t *testing.T) {
+	tests := []struct {
+		name        string
+		payload     any
+		headers     map[string]string
+		wantEmitted bool
+		wantPayload string
+		wantHeaders map[string]string
+	}{
+		{name: "nil payload and headers", wantEmitted: false},
+		{name: "nil headers", payload: []byte("new"), wantEmitted: true, wantPayload: "new", wantHeaders: map[string]string{"h": "original"}},
+		{name: "nil payload", headers: map[string]string{"h": "new"}, wantEmitted: true, wantPayload: "original", wantHeaders: map[string]string{"h": "new"}},
+		{name: "payload and headers", payload: []byte("new"), headers: map[string]string{"h": "new"}, wantEmitted: true, wantPayload: "new", wantHeaders: map[string]string{"h": "new"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				return tt.payload, tt.headers, nil
+			})
+			event := memphistest.NewEvent().AddMessage([]byte("original"), map[string]string{"h": "original"}).Build(t)
+
+			out, err := handler(context.Background(), event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(out.FailedMessages) != 0 {
+				t.Fatalf("message failed: %s", out.FailedMessages[0].Error)
+			}
+			if !tt.wantEmitted {
+				if len(out.Messages) != 0 {
+					t.Fatalf("got %d emitted messages, want the message filtered", len(out.Messages))
+				}
+				return
+			}
+			if len(out.Messages) != 1 {
+				t.Fatalf("got %d emitted messages, want 1", len(out.Messages))
+			}
+			payload, _ := base64.StdEncoding.DecodeString(out.Messages[0].Payload)
+			if string(payload) != tt.wantPayload {
+				t.Errorf("emitted payload %q, want %q", payload, tt.wantPayload)
+			}
+			if !maps.Equal(out.Messages[0].Headers, tt.wantHeaders) {
+				t.Errorf("emitted headers %v, want %v", out.Messages[0].Headers, tt.wantHeaders)
+			}
+		})
+	}
+}
+
+type contextKey struct{}
+
+func TestHandlerSignatures(t *testing.T) {
+	event := memphistest.NewEvent().AddMessage([]byte("payload"), nil).Build(t)
+	ctx := context.WithValue(context.Background(), contextKey{}, "value")
+
+	withoutContext := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		return []byte("without context"), headers, nil
+	})
+	var got any
+	withContext := functions.BuildHandlerWithContext(func(ctx context.Context, payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		got = ctx.Value(contextKey{})
+		return []byte("with context"), headers, nil
+	})
+
+	for want, handler := range map[string]func(context.Context, *functions.MemphisEvent) (*functions.MemphisOutput, error){
+		"without context": withoutContext,
+		"with context":    withContext,
+	} {
+		out, err := handler(ctx, event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out.Messages) != 1 {
+			t.Fatalf("got %d emitted messages, want 1", len(out.Messages))
+		}
+		if payload, _ := base64.StdEncoding.DecodeString(out.Messages[0].Payload); string(payload) != want {
+			t.Errorf("emitted %q, want %q", payload, want)
+		}
+	}
+	if got != "value" {
+		t.Fatalf("the handler got a context without the invocation's value, got %v", got)
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		if string(payload.([]byte)) == "2" {
+			panic("boom")
+		}
+		return payload, headers, nil
+	})
+	event := memphistest.NewEvent().
+		AddMessage([]byte("1"), nil).
+		AddMessage([]byte("2"), nil).
+		AddMessage([]byte("3"), nil).
+		Build(t)
+
+	out, err := handler(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 2 {
+		t.Fatalf("got %d emitted messages, want 2", len(out.Messages))
+	}
+	for i, want := range []string{"1", "3"} {
+		if payload, _ := base64.StdEncoding.DecodeString(out.Messages[i].Payload); string(payload) != want {
+			t.Errorf("emitted message %d is %q, want %q", i, payload, want)
+		}
+	}
+	memphistest.RequireFailed(t, out, 0, "handler panicked: boom")
+	if payload, _ := base64.StdEncoding.DecodeString(out.FailedMessages[0].Payload); string(payload) != "2" {
+		t.Fatalf("failed message is %q, want the panicking one", payload)
+	}
+}
+
+func TestConcurrentOutputOrder(t *testing.T) {
+	const n = 8
+	handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		i, _ := strconv.Atoi(string(payload.([]byte)))
+		// the first messages finish last
+		time.Sleep(time.Duration(n-i) * 5 * time.Millisecond)
+		if i%3 == 0 {
+			return nil, nil, fmt.Errorf("message %d", i)
+		}
+		return payload, headers, nil
+	}, functions.WithMaxConcurrency(n))
+	builder := memphistest.NewEvent()
+	for i := range n {
+		builder.AddMessage([]byte(strconv.Itoa(i)), nil)
+	}
+
+	out, err := handler(context.Background(), builder.Build(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var emitted, failed []string
+	for _, msg := range out.Messages {
+		payload, _ := base64.StdEncoding.DecodeString(msg.Payload)
+		emitted = append(emitted, string(payload))
+	}
+	for _, msg := range out.FailedMessages {
+		payload, _ := base64.StdEncoding.DecodeString(msg.Payload)
+		failed = append(failed, string(payload))
+	}
+	if want := []string{"1", "2", "4", "5", "7"}; !slices.Equal(emitted, want) {
+		t.Errorf("emitted %v, want %v", emitted, want)
+	}
+	if want := []string{"0", "3", "6"}; !slices.Equal(failed, want) {
+		t.Errorf("failed %v, want %v", failed, want)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		options    []functions.PayloadOption
+		wantFailed string
+	}{
+		{name: "nil results"},
+		{name: "ErrFilterMessage", err: functions.ErrFilterMessage},
+		{name: "wrapped ErrFilterMessage", err: fmt.Errorf("not for us: %w", functions.ErrFilterMessage)},
+		{name: "strict ErrFilterMessage", err: functions.ErrFilterMessage, options: []functions.PayloadOption{functions.WithStrictFilter()}},
+		{name: "strict nil results", options: []functions.PayloadOption{functions.WithStrictFilter()}, wantFailed: "without ErrFilterMessage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				return nil, nil, tt.err
+			}, tt.options...)
+			event := memphistest.NewEvent().AddMessage([]byte("payload"), nil).Build(t)
+
+			out, err := handler(context.Background(), event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(out.Messages) != 0 {
+				t.Fatalf("got %d emitted messages, want none", len(out.Messages))
+			}
+			if tt.wantFailed != "" {
+				memphistest.RequireFailed(t, out, 0, tt.wantFailed)
+			} else if len(out.FailedMessages) != 0 {
+				t.Fatalf("message failed: %s", out.FailedMessages[0].Error)
+			}
+		})
+	}
+}
+
+// largeJSONEvent returns an event of n messages with a JSON payload of about size bytes each.
+func largeJSONEvent(b *testing.B, n, size int) *functions.MemphisEvent {
+	b.Helper()
+	items := make([]record, size/32)
+	for i := range items {
+		items[i] = record{ID: i, Name: "name of the record"}
+	}
+	builder := memphistest.NewEvent()
+	for range n {
+		builder.AddJSONMessage(map[string]any{"items": items}, map[string]string{"type": "record"})
+	}
+	return builder.Build(b)
+}
+
+func BenchmarkHeadersOnly(b *testing.B) {
+	setHeader := func(headers map[string]string) map[string]string {
+		modified := maps.Clone(headers)
+		modified["seen"] = "true"
+		return modified
+	}
+	benchmarks := []struct {
+		name    string
+		handler functions.HandlerType
+		options []functions.PayloadOption
+	}{
+		{
+			name: "decoded",
+			handler: func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				return payload, setHeader(headers), nil
+			},
+			options: []functions.PayloadOption{functions.PayloadInfo(nil, functions.JSON)},
+		},
+		{
+			name: "headers only",
+			handler: func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				return nil, setHeader(headers), nil
+			},
+			options: []functions.PayloadOption{functions.PayloadInfo(nil, functions.JSON), functions.WithHeadersOnly()},
+		},
+	}
+	event := largeJSONEvent(b, 10, 256<<10)
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			handler := functions.BuildHandler(bm.handler, append(bm.options, functions.WithPerMessageLogging(false))...)
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := handler(context.Background(), event); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestFailedMessageKeepsPristineHeaders(t *testing.T) {
+	handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		headers["h"] = "mutated"
+		headers["added"] = "by the handler"
+		delete(headers, "removed")
+		return nil, nil, errors.New("failed after mutating")
+	})
+	original := map[string]string{"h": "original", "removed": "by the handler"}
+	event := memphistest.NewEvent().AddMessage([]byte("payload"), maps.Clone(original)).Build(t)
+
+	out, err := handler(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memphistest.RequireFailed(t, out, 0, "failed after mutating")
+	if headers := out.FailedMessages[0].Headers; !maps.Equal(headers, original) {
+		t.Fatalf("failed message headers %v, want the original %v", headers, original)
+	}
+}
+
+func TestChain(t *testing.T) {
+	withHeader := func(headers map[string]string, key string) map[string]string {
+		modified := maps.Clone(headers)
+		modified[key] = "1"
+		return modified
+	}
+	tests := []struct {
+		name        string
+		middle      functions.HandlerType
+		wantLast    bool
+		wantPayload string
+		wantHeaders map[string]string
+		wantFailed  string
+	}{
+		{
+			name: "error",
+			middle: func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				return nil, nil, errors.New("middle failed")
+			},
+			wantFailed: "chained handler 1: middle failed",
+		},
+		{
+			name: "filter",
+			middle: func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				return nil, nil, nil
+			},
+		},
+		{
+			name: "headers only",
+			middle: func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				return nil, withHeader(headers, "middle"), nil
+			},
+			wantLast:    true,
+			wantPayload: "first",
+			wantHeaders: map[string]string{"first": "1", "middle": "1", "last": "1"},
+		},
+		{
+			name: "[]OutMsg",
+			middle: func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				return []functions.OutMsg{{Payload: payload}}, headers, nil
+			},
+			wantFailed: "chained handler 1: only the last handler can return a []OutMsg",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lastCalled bool
+			first := func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				return []byte("first"), withHeader(headers, "first"), nil
+			}
+			last := func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				lastCalled = true
+				return payload, withHeader(headers, "last"), nil
+			}
+			handler := functions.BuildHandler(functions.Chain(first, tt.middle, last))
+			event := memphistest.NewEvent().AddMessage([]byte("payload"), map[string]string{}).Build(t)
+
+			out, err := handler(context.Background(), event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lastCalled != tt.wantLast {
+				t.Fatalf("last handler called: %v, want %v", lastCalled, tt.wantLast)
+			}
+			if tt.wantFailed != "" {
+				memphistest.RequireFailed(t, out, 0, tt.wantFailed)
+				return
+			}
+			if len(out.FailedMessages) != 0 {
+				t.Fatalf("message failed: %s", out.FailedMessages[0].Error)
+			}
+			if tt.wantHeaders == nil {
+				if len(out.Messages) != 0 {
+					t.Fatalf("got %d emitted messages, want the message filtered", len(out.Messages))
+				}
+				return
+			}
+			if len(out.Messages) != 1 {
+				t.Fatalf("got %d emitted messages, want 1", len(out.Messages))
+			}
+			payload, _ := base64.StdEncoding.DecodeString(out.Messages[0].Payload)
+			if string(payload) != tt.wantPayload || !maps.Equal(out.Messages[0].Headers, tt.wantHeaders) {
+				t.Fatalf("emitted %q with headers %v, want %q with %v", payload, out.Messages[0].Headers, tt.wantPayload, tt.wantHeaders)
+			}
+		})
+	}
+}
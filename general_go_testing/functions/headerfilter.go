@@ -0,0 +1,75 @@
+package functions
+
+import (
+	"errors"
+	"strings"
+)
+
+var errHeaderAllowAndDenylist = errors.New("WithHeaderAllowlist and WithHeaderDenylist can't be used together")
+
+// WithHeaderAllowlist strips every header but the keys from the emitted messages, once the handler
+// and WithStandardHeaders have set them. Keys are matched case-insensitively, no key strips them all.
+// The headers set by WithOutputCompression, WithPayloadCrypto and WithClaimCheckThreshold are added afterwards, so they are always kept.
+// Failed messages keep their original headers. The stripped headers are counted in the Stats.
+func WithHeaderAllowlist(keys ...string) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.HeaderAllowlist = headerKeySet(keys)
+		return nil
+	}
+}
+
+// WithHeaderDenylist strips the headers keys from the emitted messages, once the handler
+// and WithStandardHeaders have set them. Keys are matched case-insensitively.
+// The headers set by WithOutputCompression, WithPayloadCrypto and WithClaimCheckThreshold are added afterwards, so they are always kept.
+// Failed messages keep their original headers. The stripped headers are counted in the Stats.
+func WithHeaderDenylist(keys ...string) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.HeaderDenylist = headerKeySet(keys)
+		return nil
+	}
+}
+
+// headerKeySet returns the set of the lowercased keys.
+func headerKeySet(keys []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		set[strings.ToLower(key)] = struct{}{}
+	}
+	return set
+}
+
+// filterHeaders returns headers without the headers stripped by the allowlist or the denylist, and how many were stripped.
+// headers itself is returned when none is stripped.
+func (payloadOptions *PayloadOptions) filterHeaders(headers map[string]string) (map[string]string, int) {
+	if payloadOptions.HeaderAllowlist == nil && payloadOptions.HeaderDenylist == nil {
+		return headers, 0
+	}
+
+	strip := func(key string) bool {
+		key = strings.ToLower(key)
+		if payloadOptions.HeaderAllowlist != nil {
+			_, ok := payloadOptions.HeaderAllowlist[key]
+			return !ok
+		}
+		_, ok := payloadOptions.HeaderDenylist[key]
+		return ok
+	}
+
+	var filtered map[string]string
+	var stripped int
+	for key := range headers {
+		if !strip(key) {
+			continue
+		}
+		if filtered == nil {
+			// the headers may be shared with the input message, so they are copied before deleting
+			filtered = copyHeaders(headers)
+		}
+		delete(filtered, key)
+		stripped++
+	}
+	if filtered == nil {
+		return headers, 0
+	}
+	return filtered, stripped
+}
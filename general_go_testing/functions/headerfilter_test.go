@@ -0,0 +1,61 @@
+package functions_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"io"
+	"testing"
+
+	"go_template/functions"
+	"go_template/memphistest"
+)
+
+func TestHeaderAllowlistKeepsCompressionHeader(t *testing.T) {
+	handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		return payload, map[string]string{"keep": "1", "drop": "2"}, nil
+	}, functions.WithCompression(), functions.WithOutputCompression("gzip", 0), functions.WithHeaderAllowlist("keep"), functions.WithStats())
+	event := memphistest.NewEvent().AddMessage(gzipped(t, []byte("payload")), map[string]string{functions.ContentEncodingHeader: "gzip"}).Build(t)
+
+	out, err := handler(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 1 {
+		t.Fatalf("got %d emitted messages, failed: %+v", len(out.Messages), out.FailedMessages)
+	}
+
+	headers := out.Messages[0].Headers
+	if headers["keep"] != "1" || headers[functions.ContentEncodingHeader] != "gzip" || len(headers) != 2 {
+		t.Fatalf("emitted headers %v, want keep and the content encoding", headers)
+	}
+	if out.Stats.StrippedHeaders != 1 {
+		t.Fatalf("stripped %d headers, want 1", out.Stats.StrippedHeaders)
+	}
+
+	payload, _ := base64.StdEncoding.DecodeString(out.Messages[0].Payload)
+	gzipReader, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("emitted payload isn't gzipped: %v", err)
+	}
+	decompressed, err := io.ReadAll(gzipReader)
+	if err != nil || string(decompressed) != "payload" {
+		t.Fatalf("emitted payload decompresses to %q, %v", decompressed, err)
+	}
+}
+
+func TestHeaderDenylistKeepsCompressionHeader(t *testing.T) {
+	handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		return payload, map[string]string{"keep": "1"}, nil
+	}, functions.WithOutputCompression("gzip", 0), functions.WithHeaderDenylist(functions.ContentEncodingHeader))
+	event := memphistest.NewEvent().AddMessage([]byte("payload"), nil).Build(t)
+
+	out, err := handler(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 1 || out.Messages[0].Headers[functions.ContentEncodingHeader] != "gzip" {
+		t.Fatalf("emitted %+v, want the content encoding kept", out.Messages)
+	}
+}
@@ -0,0 +1,326 @@
+package functions
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers are message headers with case-insensitive accessors, so "Content-Type" and "content-type" are the same header.
+// The keys of the map keep the casing they were received with, new keys are canonicalized like net/http does.
+// Converting the headers a HandlerType gets, Headers(headers).GetInt("retries"), is the recommended way to read them,
+// the typed getters report missing and malformed values the same way everywhere.
+type Headers map[string]string
+
+// Get returns the value of the header key, or "" if there is none.
+func (h Headers) Get(key string) string {
+	value, _ := h.lookup(key)
+	return value
+}
+
+// Has tells whether the header key is set.
+func (h Headers) Has(key string) bool {
+	_, ok := h.lookup(key)
+	return ok
+}
+
+// Set sets the header key to value, replacing the value of an existing header under its original casing.
+func (h Headers) Set(key, value string) {
+	if existing, ok := h.key(key); ok {
+		h[existing] = value
+		return
+	}
+	h[textproto.CanonicalMIMEHeaderKey(key)] = value
+}
+
+// Del removes the header key in every casing it is set with.
+func (h Headers) Del(key string) {
+	for k := range h {
+		if strings.EqualFold(k, key) {
+			delete(h, k)
+		}
+	}
+}
+
+// GetInt returns the value of the header key parsed as a base 10 int, ok is false if there is none.
+func (h Headers) GetInt(key string) (int, bool, error) {
+	return getHeader(h, key, strconv.Atoi)
+}
+
+// SetInt sets the header key to value in base 10.
+func (h Headers) SetInt(key string, value int) {
+	h.Set(key, strconv.Itoa(value))
+}
+
+// GetBool returns the value of the header key parsed like strconv.ParseBool, ok is false if there is none.
+func (h Headers) GetBool(key string) (bool, bool, error) {
+	return getHeader(h, key, strconv.ParseBool)
+}
+
+// SetBool sets the header key to "true" or "false".
+func (h Headers) SetBool(key string, value bool) {
+	h.Set(key, strconv.FormatBool(value))
+}
+
+// GetTime returns the value of the header key parsed as an RFC3339 time, ok is false if there is none.
+func (h Headers) GetTime(key string) (time.Time, bool, error) {
+	return getHeader(h, key, func(value string) (time.Time, error) { return time.Parse(time.RFC3339, value) })
+}
+
+// SetTime sets the header key to value in RFC3339 with nanoseconds, which GetTime parses.
+func (h Headers) SetTime(key string, value time.Time) {
+	h.Set(key, value.Format(time.RFC3339Nano))
+}
+
+// GetDuration returns the value of the header key parsed like time.ParseDuration, e.g. "1m30s", ok is false if there is none.
+func (h Headers) GetDuration(key string) (time.Duration, bool, error) {
+	return getHeader(h, key, time.ParseDuration)
+}
+
+// SetDuration sets the header key to value in the syntax GetDuration parses.
+func (h Headers) SetDuration(key string, value time.Duration) {
+	h.Set(key, value.String())
+}
+
+// getHeader parses the value of the header key with parse, ok is false if there is none.
+func getHeader[T any](h Headers, key string, parse func(string) (T, error)) (T, bool, error) {
+	var zero T
+	value, ok := h.lookup(key)
+	if !ok {
+		return zero, false, nil
+	}
+
+	parsed, err := parse(value)
+	if err != nil {
+		return zero, true, fmt.Errorf("invalid header %s: %w", key, err)
+	}
+	return parsed, true, nil
+}
+
+// HeaderValueSeparator separates the values of a header with several values, like the repeated headers of HTTP.
+// AddValue escapes the separator and the % in the values as %2C and %25, GetAll splits and unescapes them,
+// so the headers stay a map[string]string on the wire and the functions pass them through untouched.
+const HeaderValueSeparator = ","
+
+// GetAll returns the values of the header key, nil if there is none.
+// The whitespace around the values is trimmed, so "a, b" has the values "a" and "b".
+func (h Headers) GetAll(key string) []string {
+	value, ok := h.lookup(key)
+	if !ok {
+		return nil
+	}
+
+	values := strings.Split(value, HeaderValueSeparator)
+	for i, v := range values {
+		values[i] = headerValueUnescaper.Replace(strings.TrimSpace(v))
+	}
+	return values
+}
+
+// AddValue adds value to the values of the header key, setting it if there is none.
+func (h Headers) AddValue(key, value string) {
+	value = headerValueEscaper.Replace(value)
+	if existing, ok := h.lookup(key); ok {
+		value = existing + HeaderValueSeparator + value
+	}
+	h.Set(key, value)
+}
+
+var (
+	headerValueEscaper   = strings.NewReplacer("%", "%25", HeaderValueSeparator, "%2C")
+	headerValueUnescaper = strings.NewReplacer("%2C", HeaderValueSeparator, "%2c", HeaderValueSeparator, "%25", "%")
+)
+
+// BinaryHeaderSuffix ends the keys of the headers carrying binary values, which are base64 encoded on the wire.
+// The functions pass them through untouched, SetBinary and GetBinary encode and decode them.
+const BinaryHeaderSuffix = "-bin"
+
+// SetBinary sets the binary header key to value, base64 encoded. BinaryHeaderSuffix is appended to key if it doesn't end with it.
+func (h Headers) SetBinary(key string, value []byte) {
+	h.Set(binaryHeaderKey(key), base64.StdEncoding.EncodeToString(value))
+}
+
+// GetBinary returns the decoded value of the binary header key, or nil if there is none.
+// BinaryHeaderSuffix is appended to key if it doesn't end with it. Padded and unpadded base64 are both accepted.
+func (h Headers) GetBinary(key string) ([]byte, error) {
+	key = binaryHeaderKey(key)
+	value, ok := h.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		if decoded, err = base64.RawStdEncoding.DecodeString(value); err != nil {
+			return nil, fmt.Errorf("invalid binary header %s: %w", key, err)
+		}
+	}
+	return decoded, nil
+}
+
+func binaryHeaderKey(key string) string {
+	if len(key) >= len(BinaryHeaderSuffix) && strings.EqualFold(key[len(key)-len(BinaryHeaderSuffix):], BinaryHeaderSuffix) {
+		return key
+	}
+	return key + BinaryHeaderSuffix
+}
+
+func (h Headers) lookup(key string) (string, bool) {
+	k, ok := h.key(key)
+	if !ok {
+		return "", false
+	}
+	return h[k], true
+}
+
+// key returns the key under which the header key is actually stored.
+func (h Headers) key(key string) (string, bool) {
+	if _, ok := h[key]; ok {
+		return key, true
+	}
+	for k := range h {
+		if strings.EqualFold(k, key) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// HeaderNormalization is how WithHeaderNormalization rewrites the keys of the headers.
+type HeaderNormalization int
+
+const (
+	// HeaderNormalizationOff keeps the keys as they are, it is the default.
+	HeaderNormalizationOff HeaderNormalization = iota
+	// LowercaseHeaders lowercases the keys, e.g. "content-type".
+	LowercaseHeaders
+	// CanonicalMIMEHeaders canonicalizes the keys like net/http does, e.g. "Content-Type".
+	CanonicalMIMEHeaders
+)
+
+// WithHeaderNormalization rewrites the keys of the headers of the incoming messages before the handler gets them,
+// and of the emitted messages, so downstream consumers get a single casing whatever the producers sent.
+// When keys of the emitted headers collide, the value of the key that wasn't normalized yet wins,
+// as the handler set it over an incoming header.
+func WithHeaderNormalization(normalization HeaderNormalization) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if normalization < HeaderNormalizationOff || normalization > CanonicalMIMEHeaders {
+			return fmt.Errorf("invalid header normalization %d", normalization)
+		}
+		payloadOptions.HeaderNormalization = normalization
+		return nil
+	}
+}
+
+// normalizeHeaders returns a copy of headers with their keys normalized, or headers itself without normalization.
+func (payloadOptions *PayloadOptions) normalizeHeaders(headers map[string]string) map[string]string {
+	if payloadOptions.HeaderNormalization == HeaderNormalizationOff || headers == nil {
+		return headers
+	}
+
+	normalized := make(map[string]string, len(headers))
+	// sorted so the value kept among colliding keys doesn't depend on the map order
+	for _, key := range slices.Sorted(maps.Keys(headers)) {
+		normalizedKey := strings.ToLower(key)
+		if payloadOptions.HeaderNormalization == CanonicalMIMEHeaders {
+			normalizedKey = textproto.CanonicalMIMEHeaderKey(key)
+		}
+		if _, ok := normalized[normalizedKey]; ok && key == normalizedKey {
+			continue
+		}
+		normalized[normalizedKey] = headers[key]
+	}
+	return normalized
+}
+
+// copyHeaders returns a copy of headers, nil if headers is nil.
+func copyHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(headers))
+	for k, v := range headers {
+		copied[k] = v
+	}
+	return copied
+}
+
+// Standard headers set on the emitted messages by WithStandardHeaders.
+const (
+	ProcessedAtHeader        = "processed-at"
+	ProcessedByHeader        = "processed-by"
+	ProcessedByVersionHeader = "processed-by-version"
+	AWSRequestIDHeader       = "aws-request-id"
+)
+
+// WithStandardHeaders sets the standard headers on every emitted message: the time it was processed at in RFC3339Nano,
+// the name and version of the function and the AWS request ID. Headers already set by the handler are kept.
+func WithStandardHeaders() PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.StandardHeaders = true
+		return nil
+	}
+}
+
+// standardHeaders returns a copy of headers with the standard headers missing from it set.
+func standardHeaders(ctx context.Context, headers map[string]string) map[string]string {
+	stamped := Headers(copyHeaders(headers))
+	if stamped == nil {
+		stamped = Headers{}
+	}
+	setMissing := func(key, value string) {
+		if value != "" && !stamped.Has(key) {
+			stamped[key] = value
+		}
+	}
+
+	setMissing(ProcessedAtHeader, time.Now().UTC().Format(time.RFC3339Nano))
+	setMissing(ProcessedByHeader, functionName())
+	setMissing(ProcessedByVersionHeader, functionVersion())
+	if requestID, ok := awsRequestID(ctx); ok {
+		setMissing(AWSRequestIDHeader, requestID)
+	}
+
+	return stamped
+}
+
+// WithDefaultHeaders sets headers on every emitted message that doesn't have them already.
+// The headers set by the handler and by WithStandardHeaders take precedence over them.
+// WithHeaderAllowlist and WithHeaderDenylist apply to them like to any other header.
+func WithDefaultHeaders(headers map[string]string) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.DefaultHeaders = maps.Clone(headers)
+		return nil
+	}
+}
+
+// defaultHeaders returns a copy of headers with the default headers missing from it set.
+func (payloadOptions *PayloadOptions) defaultHeaders(headers map[string]string) map[string]string {
+	withDefaults := Headers(copyHeaders(headers))
+	if withDefaults == nil {
+		withDefaults = make(Headers, len(payloadOptions.DefaultHeaders))
+	}
+	for key, value := range payloadOptions.DefaultHeaders {
+		if !withDefaults.Has(key) {
+			withDefaults[key] = value
+		}
+	}
+	return withDefaults
+}
+
+// HeadersHandlerType functions behave like HandlerType functions but get and return the message headers as Headers.
+type HeadersHandlerType func(any, Headers, map[string]string) (any, Headers, error)
+
+// withHeaders adapts a HeadersHandlerType to a HandlerType.
+func withHeaders(eventHandler HeadersHandlerType) HandlerType {
+	return func(message any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		modifiedMessage, modifiedHeaders, err := eventHandler(message, Headers(headers), inputs)
+		return modifiedMessage, modifiedHeaders, err
+	}
+}
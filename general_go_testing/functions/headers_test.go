@@ -0,0 +1,236 @@
+package functions_test
+
+import (
+	"context"
+	"maps"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"go_template/functions"
+	"go_template/memphistest"
+)
+
+func TestHeaderPrecedence(t *testing.T) {
+	handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		return []functions.OutMsg{
+			{Payload: payload, Headers: map[string]string{"owner": "handler"}},
+			{Payload: payload, Headers: map[string]string{functions.ProcessedAtHeader: "handler"}},
+		}, nil, nil
+	}, functions.WithStandardHeaders(), functions.WithDefaultHeaders(map[string]string{
+		"owner":                     "default",
+		"team":                      "default",
+		functions.ProcessedAtHeader: "default",
+	}))
+	event := memphistest.NewEvent().AddMessage([]byte("payload"), nil).Build(t)
+
+	out, err := handler(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 2 {
+		t.Fatalf("got %d emitted messages, failed: %+v", len(out.Messages), out.FailedMessages)
+	}
+
+	// defaults < standard headers < handler headers
+	first, second := out.Messages[0].Headers, out.Messages[1].Headers
+	if first["owner"] != "handler" {
+		t.Errorf("owner = %q, want the handler header over the default one", first["owner"])
+	}
+	if first["team"] != "default" {
+		t.Errorf("team = %q, want the default header", first["team"])
+	}
+	if got := first[functions.ProcessedAtHeader]; got == "default" || got == "" {
+		t.Errorf("%s = %q, want the standard header over the default one", functions.ProcessedAtHeader, got)
+	}
+	if got := second[functions.ProcessedAtHeader]; got != "handler" {
+		t.Errorf("%s = %q, want the handler header over the standard one", functions.ProcessedAtHeader, got)
+	}
+}
+
+func TestTypedHeaderGetters(t *testing.T) {
+	headers := functions.Headers{
+		"Int":          "42",
+		"Bad-Int":      "4x2",
+		"Bool":         "true",
+		"Bad-Bool":     "yes",
+		"Time":         "2024-05-01T10:00:00Z",
+		"Nano-Time":    "2024-05-01T10:00:00.123456789+02:00",
+		"Bad-Time":     "2024-05-01 10:00",
+		"Duration":     "1m30s",
+		"Bad-Duration": "90",
+	}
+	type result struct {
+		value any
+		ok    bool
+		err   bool
+	}
+	get := func(value any, ok bool, err error) result { return result{value: value, ok: ok, err: err != nil} }
+
+	tests := []struct {
+		name string
+		got  result
+		want result
+	}{
+		{name: "int", got: get(headers.GetInt("int")), want: result{value: 42, ok: true}},
+		{name: "missing int", got: get(headers.GetInt("missing")), want: result{value: 0}},
+		{name: "malformed int", got: get(headers.GetInt("bad-int")), want: result{value: 0, ok: true, err: true}},
+		{name: "bool", got: get(headers.GetBool("BOOL")), want: result{value: true, ok: true}},
+		{name: "missing bool", got: get(headers.GetBool("missing")), want: result{value: false}},
+		{name: "malformed bool", got: get(headers.GetBool("bad-bool")), want: result{value: false, ok: true, err: true}},
+		{name: "time", got: get(headers.GetTime("time")), want: result{value: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), ok: true}},
+		{name: "time with nanoseconds", got: get(headers.GetTime("nano-time")), want: result{value: time.Date(2024, 5, 1, 8, 0, 0, 123456789, time.UTC), ok: true}},
+		{name: "missing time", got: get(headers.GetTime("missing")), want: result{value: time.Time{}}},
+		{name: "malformed time", got: get(headers.GetTime("bad-time")), want: result{value: time.Time{}, ok: true, err: true}},
+		{name: "duration", got: get(headers.GetDuration("duration")), want: result{value: 90 * time.Second, ok: true}},
+		{name: "missing duration", got: get(headers.GetDuration("missing")), want: result{value: time.Duration(0)}},
+		{name: "malformed duration", got: get(headers.GetDuration("bad-duration")), want: result{value: time.Duration(0), ok: true, err: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if gotTime, ok := tt.got.value.(time.Time); ok {
+				if !gotTime.Equal(tt.want.value.(time.Time)) {
+					t.Fatalf("got %v, want %v", gotTime, tt.want.value)
+				}
+				tt.got.value = tt.want.value
+			}
+			if tt.got != tt.want {
+				t.Fatalf("got %+v, want %+v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypedHeaderSetters(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 0, 0, 123456789, time.UTC)
+	headers := functions.Headers{}
+	headers.SetInt("int", -7)
+	headers.SetBool("bool", false)
+	headers.SetTime("time", at)
+	headers.SetDuration("duration", 1500*time.Millisecond)
+
+	if value, ok, err := headers.GetInt("int"); value != -7 || !ok || err != nil {
+		t.Errorf("GetInt = %v, %v, %v", value, ok, err)
+	}
+	if value, ok, err := headers.GetBool("bool"); value || !ok || err != nil {
+		t.Errorf("GetBool = %v, %v, %v", value, ok, err)
+	}
+	if value, ok, err := headers.GetTime("time"); !value.Equal(at) || !ok || err != nil {
+		t.Errorf("GetTime = %v, %v, %v", value, ok, err)
+	}
+	if value, ok, err := headers.GetDuration("duration"); value != 1500*time.Millisecond || !ok || err != nil {
+		t.Errorf("GetDuration = %v, %v, %v", value, ok, err)
+	}
+}
+
+func TestHeaderNormalizationCollision(t *testing.T) {
+	tests := []struct {
+		name          string
+		normalization functions.HeaderNormalization
+		key           string
+	}{
+		{name: "lowercase", normalization: functions.LowercaseHeaders, key: "x-foo"},
+		{name: "canonical MIME", normalization: functions.CanonicalMIMEHeaders, key: "X-Foo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				modified := maps.Clone(headers)
+				modified["X-Foo"] = "handler"
+				return payload, modified, nil
+			}, functions.WithHeaderNormalization(tt.normalization))
+			event := memphistest.NewEvent().AddMessage([]byte("payload"), map[string]string{"x-foo": "original"}).Build(t)
+
+			out, err := handler(context.Background(), event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(out.Messages) != 1 {
+				t.Fatalf("got %d emitted messages, failed: %+v", len(out.Messages), out.FailedMessages)
+			}
+			if want := map[string]string{tt.key: "handler"}; !maps.Equal(out.Messages[0].Headers, want) {
+				t.Fatalf("emitted headers %v, want %v", out.Messages[0].Headers, want)
+			}
+		})
+	}
+}
+
+type fakeRegistryClient map[string]functions.RegistrySchema
+
+func (c fakeRegistryClient) GetSchema(ctx context.Context, id string) (functions.RegistrySchema, error) {
+	return c[id], nil
+}
+
+func TestSchemaRegistryWithHeaderNormalization(t *testing.T) {
+	var got any
+	handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		got = payload
+		return payload, headers, nil
+	}, functions.WithSchemaRegistry(fakeRegistryClient{"1": {Type: "JSON", Schema: `{"type":"object"}`}}), functions.WithHeaderNormalization(functions.CanonicalMIMEHeaders))
+	event := memphistest.NewEvent().AddMessage([]byte(`{"a":1}`), map[string]string{functions.SchemaIDHeader: "1"}).Build(t)
+
+	out, err := handler(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 1 {
+		t.Fatalf("got %d emitted messages, failed: %+v", len(out.Messages), out.FailedMessages)
+	}
+	if want := &map[string]any{"a": float64(1)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("handler got %#v, want %#v decoded with the schema", got, want)
+	}
+}
+
+func TestTracingWithHeaderNormalization(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	var got trace.TraceID
+	handler := functions.BuildHandlerWithContext(func(ctx context.Context, payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		got = trace.SpanContextFromContext(ctx).TraceID()
+		return payload, headers, nil
+	}, functions.WithTracing(noop.NewTracerProvider()), functions.WithHeaderNormalization(functions.CanonicalMIMEHeaders))
+	event := memphistest.NewEvent().AddMessage([]byte("payload"), map[string]string{"Traceparent": "00-" + traceID + "-00f067aa0ba902b7-01"}).Build(t)
+
+	if _, err := handler(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != traceID {
+		t.Fatalf("handler span has trace id %s, want the one of the traceparent header %s", got, traceID)
+	}
+}
+
+func TestSetDestination(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{name: "nil headers", headers: nil},
+		{name: "other headers", headers: map[string]string{"h": "v"}},
+		{name: "other casing", headers: map[string]string{"h": "v", "X-Memphis-Destination": "old"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				return payload, functions.SetDestination(tt.headers, "station"), nil
+			})
+			event := memphistest.NewEvent().AddMessage([]byte("payload"), nil).Build(t)
+
+			out, err := handler(context.Background(), event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(out.Messages) != 1 {
+				t.Fatalf("got %d emitted messages, failed: %+v", len(out.Messages), out.FailedMessages)
+			}
+			want := map[string]string{functions.DestinationHeader: "station"}
+			if tt.headers != nil {
+				want["h"] = "v"
+			}
+			if msg := out.Messages[0]; !maps.Equal(msg.Headers, want) || msg.Destination != "station" {
+				t.Fatalf("emitted headers %v with destination %q, want %v", msg.Headers, msg.Destination, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go_template/internal/httpjson"
+)
+
+// DefaultMaxRequestBodySize is the size of the largest event ServeHTTP accepts by default, the payload limit of a synchronous Lambda invocation.
+const DefaultMaxRequestBodySize = 6 << 20
+
+// httpShutdownTimeout bounds how long ServeHTTP waits for the requests in flight when it is shut down.
+const httpShutdownTimeout = 30 * time.Second
+
+// WithMaxRequestBodySize sets the size of the largest event ServeHTTP accepts, larger requests are rejected with a 413 status.
+func WithMaxRequestBodySize(maxBytes int64) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if maxBytes <= 0 {
+			return fmt.Errorf("max request body size must be positive, got %d", maxBytes)
+		}
+		payloadOptions.MaxRequestBodySize = maxBytes
+		return nil
+	}
+}
+
+// ServeHTTP runs the function as an HTTP server on addr instead of on Lambda, e.g. in a container,
+// processing events exactly like CreateFunction does: every POST request gets a MemphisEvent as JSON body
+// and responds with the MemphisOutput, or with a 500 status and the error when the invocation fails.
+// GET /healthz responds with a 200 status while the server runs.
+// On SIGTERM or SIGINT the server stops accepting requests, waits for the ones in flight and runs the WithShutdown function.
+func ServeHTTP(addr string, eventHandler HandlerType, options ...PayloadOption) error {
+	function := NewFunction(eventHandler, options...)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.Handle("POST /", httpHandler(function))
+	return function.Serve(&http.Server{Addr: addr, Handler: mux})
+}
+
+// Serve runs server, which serves the function's events, until SIGTERM or SIGINT,
+// then shuts it down gracefully and runs the WithShutdown function.
+func (function *Function) Serve(server *http.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	served := make(chan error, 1)
+	go func() { served <- server.ListenAndServe() }()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+	if function.config != nil && function.config.Shutdown != nil {
+		function.config.Shutdown.run(function.logger())
+	}
+	return err
+}
+
+// NewHTTPHandler returns the http.Handler ServeHTTP serves the events with, for platforms that run HTTP functions,
+// the gcf package registers it with the Google Cloud Functions framework. It processes every request as an event regardless of its method.
+func NewHTTPHandler(eventHandler HandlerType, options ...PayloadOption) http.Handler {
+	return httpHandler(NewFunction(eventHandler, options...))
+}
+
+// MaxRequestBodySize returns the size of the largest event the function accepts over HTTP, set by WithMaxRequestBodySize.
+func (function *Function) MaxRequestBodySize() int64 {
+	if function.config == nil || function.config.MaxRequestBodySize == 0 {
+		return DefaultMaxRequestBodySize
+	}
+	return function.config.MaxRequestBodySize
+}
+
+// httpError is the body of the responses to the failed invocations, like the error of a failed Lambda invocation.
+type httpError struct {
+	ErrorMessage string `json:"errorMessage"`
+}
+
+// httpHandler serves the events of function, rejecting bodies larger than its MaxRequestBodySize.
+func httpHandler(function *Function) http.Handler {
+	maxBodySize := function.MaxRequestBodySize()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event MemphisEvent
+		if status, err := httpjson.DecodeRequestBody(w, r, maxBodySize, &event); err != nil {
+			httpjson.WriteJSON(w, status, httpError{ErrorMessage: fmt.Sprintf("invalid event: %v", err)})
+			return
+		}
+
+		output, err := function.Invoke(r.Context(), &event)
+		if err != nil {
+			httpjson.WriteJSON(w, http.StatusInternalServerError, httpError{ErrorMessage: err.Error()})
+			return
+		}
+		httpjson.WriteJSON(w, http.StatusOK, output)
+	})
+}
@@ -0,0 +1,158 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type inputsContextKey struct{}
+
+// WithInputs decodes the inputs of every invocation into a fresh copy of schema, a pointer to a struct whose fields
+// are tagged with the input name and optionally a default, e.g. `input:"batch_size" default:"100"`.
+// Handlers get the decoded struct with TypedInputs.
+func WithInputs(schema any) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		schemaType := reflect.TypeOf(schema)
+		if schemaType == nil || schemaType.Kind() != reflect.Pointer || schemaType.Elem().Kind() != reflect.Struct {
+			return fmt.Errorf("inputs schema must be a pointer to a struct, got %v", schemaType)
+		}
+		payloadOptions.InputsSchema = schema
+		return nil
+	}
+}
+
+// TypedInputs returns the inputs of the invocation decoded by WithInputs, ok is false when they weren't decoded into a *T.
+func TypedInputs[T any](ctx context.Context) (inputs *T, ok bool) {
+	inputs, ok = ctx.Value(inputsContextKey{}).(*T)
+	return inputs, ok
+}
+
+// WithRequiredInputs fails the invocation, before any message is processed, when one of the inputs is missing.
+func WithRequiredInputs(names ...string) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.RequiredInputs = append(payloadOptions.RequiredInputs, names...)
+		return nil
+	}
+}
+
+// WithInputsValidator fails the invocation, before any message is processed, when validator returns an error,
+// e.g. for checks across several inputs.
+func WithInputsValidator(validator func(map[string]string) error) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.InputsValidator = validator
+		return nil
+	}
+}
+
+// checkInputs returns a single error naming all the missing required inputs, or the error of the InputsValidator.
+func (payloadOptions *PayloadOptions) checkInputs(inputs map[string]string) error {
+	var missing []string
+	for _, name := range payloadOptions.RequiredInputs {
+		if _, ok := inputs[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required inputs: %s", strings.Join(missing, ", "))
+	}
+
+	if payloadOptions.InputsValidator != nil {
+		if err := payloadOptions.InputsValidator(inputs); err != nil {
+			return fmt.Errorf("invalid inputs: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// decodeInputs decodes inputs into a new instance of the struct schema points to.
+func decodeInputs(inputs map[string]string, schema any) (any, error) {
+	typedInputs := newUserObject(schema)
+	structValue := reflect.ValueOf(typedInputs).Elem()
+	structType := structValue.Type()
+
+	for i := range structType.NumField() {
+		field := structType.Field(i)
+		name, ok := field.Tag.Lookup("input")
+		if !ok || !field.IsExported() {
+			continue
+		}
+
+		value, ok := inputs[name]
+		if !ok {
+			if value, ok = field.Tag.Lookup("default"); !ok {
+				continue
+			}
+		}
+
+		if err := setFieldFromString(structValue.Field(i), value); err != nil {
+			return nil, fmt.Errorf("couldn't decode input %q: %w", name, err)
+		}
+	}
+
+	return typedInputs, nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setFieldFromString parses value into field according to the field's type.
+func setFieldFromString(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(parsed)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported field type %v", field.Type())
+		}
+		items := strings.Split(value, ",")
+		if value == "" {
+			items = nil
+		}
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			slice.Index(i).SetString(strings.TrimSpace(item))
+		}
+		field.Set(slice)
+	default:
+		return fmt.Errorf("unsupported field type %v", field.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+//go:build !gcf
+
+package functions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// The Lambda runtime is left out of the builds with the gcf tag, so functions deployed with the gcf package don't link it.
+
+// This function creates a Memphis function and processes events with the passed-in eventHandler function.
+// eventHandler gets the message payload as []byte or as the user specified type,
+// message headers as map[string]string and inputs as map[string]string and should return the modified payload and headers.
+// The modified payload type will either be the user type, or []byte depending on user requirements.
+// error should be returned if the message should be considered failed and go into the dead-letter station.
+// if all returned values are nil the message will be filtered out from the station.
+func CreateFunction(eventHandler HandlerType, options ...PayloadOption) {
+	startFunction(NewFunction(eventHandler, options...))
+}
+
+// NewHandler returns the lambda.Handler CreateFunction starts the Lambda runtime with, so it can be wrapped
+// with other Lambda middlewares or started with lambda.StartWithOptions, e.g. to pass lambda.WithContext.
+// WithShutdown then needs lambda.WithEnableSIGTERM to be passed to the runtime, which CreateFunction otherwise does.
+func NewHandler(eventHandler HandlerType, options ...PayloadOption) lambda.Handler {
+	return lambdaHandler(BuildHandler(eventHandler, options...))
+}
+
+// This function creates a Memphis function exactly like CreateFunction,
+// except that eventHandler also gets the context of the Lambda invocation as its first argument.
+func CreateFunctionWithContext(eventHandler HandlerWithContextType, options ...PayloadOption) {
+	startFunction(newFunction(eventHandler, options))
+}
+
+// This function creates a Memphis function whose eventHandler receives the message payload as a *T instead of any.
+// A fresh T is allocated and unmarshaled for every message, and the returned *T is marshaled according to the PayloadType.
+// The PayloadType defaults to JSON, options may still be used to override it.
+func CreateTypedFunction[T any](eventHandler TypedHandlerType[T], options ...PayloadOption) {
+	options = append([]PayloadOption{PayloadInfo(nil, JSON)}, options...)
+	options = append(options, userSchema(new(T)))
+	CreateFunction(typedHandler(eventHandler), options...)
+}
+
+// This function creates a Memphis function whose eventHandler processes all the messages of an event at once,
+// the messages are decoded and the outputs encoded according to the PayloadType like with CreateFunction.
+// The per-message options, like WithMessageTimeout, WithMaxConcurrency or WithMiddleware, don't apply.
+func CreateBatchFunction(eventHandler BatchHandlerType, options ...PayloadOption) {
+	startFunction(newBatchFunction(eventHandler, options))
+}
+
+// This function creates a Memphis function exactly like CreateFunction,
+// except that eventHandler gets the message headers as Headers with case-insensitive accessors.
+func CreateFunctionWithHeaders(eventHandler HeadersHandlerType, options ...PayloadOption) {
+	CreateFunction(withHeaders(eventHandler), options...)
+}
+
+// lambdaHandler adapts the functions returned by BuildHandler and its variants to the lambda.Handler interface.
+type lambdaHandler func(context.Context, *MemphisEvent) (*MemphisOutput, error)
+
+// Invoke decodes the MemphisEvent of an invocation and encodes its MemphisOutput, like the Lambda runtime does for handler functions.
+func (handler lambdaHandler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	var event MemphisEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+
+	output, err := handler(ctx, &event)
+	if err != nil {
+		return nil, err
+	}
+
+	var response bytes.Buffer
+	encoder := json.NewEncoder(&response)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(output); err != nil {
+		return nil, err
+	}
+	return response.Bytes(), nil
+}
+
+// startFunction starts the Lambda runtime with function, enabling SIGTERM when a WithShutdown function is set.
+func startFunction(function *Function) {
+	var lambdaOptions []lambda.Option
+	if function.config != nil && function.config.Shutdown != nil {
+		lambdaOptions = append(lambdaOptions, lambda.WithEnableSIGTERM(func() { function.config.Shutdown.run(function.logger()) }))
+	}
+
+	lambda.StartWithOptions(lambdaHandler(function.Invoke), lambdaOptions...)
+}
+
+// awsRequestID returns the ID of the Lambda invocation of ctx.
+func awsRequestID(ctx context.Context) (string, bool) {
+	lambdaContext, ok := lambdacontext.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return lambdaContext.AwsRequestID, true
+}
+
+// functionName and functionVersion return the name and version of the Lambda function, empty outside of Lambda.
+func functionName() string    { return lambdacontext.FunctionName }
+func functionVersion() string { return lambdacontext.FunctionVersion }
@@ -0,0 +1,12 @@
+//go:build gcf
+
+package functions
+
+import "context"
+
+// awsRequestID returns false, outside of Lambda there is no AWS request ID.
+func awsRequestID(context.Context) (string, bool) { return "", false }
+
+// functionName and functionVersion return the name and version of the Lambda function, empty outside of Lambda.
+func functionName() string    { return "" }
+func functionVersion() string { return "" }
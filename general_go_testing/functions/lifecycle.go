@@ -0,0 +1,128 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type initContextKey struct{}
+
+// initState remembers the value of a successful init function across the invocations of a Lambda instance.
+type initState struct {
+	init      func(context.Context, map[string]string) (any, error)
+	mu        sync.Mutex
+	done      bool
+	value     any
+	resources *resourceSet
+}
+
+// WithInit runs init once per cold start, on the first invocation so that it gets the inputs of the first event,
+// e.g. to open database pools. A failing init fails the invocation and is retried on the next one.
+// Handlers get the value returned by init with InitValue, and the resources init sets with SetResource with Resource.
+func WithInit(init func(ctx context.Context, inputs map[string]string) (any, error)) PayloadOption {
+	state := &initState{init: init, resources: &resourceSet{values: map[string]any{}}}
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.Init = state
+		return nil
+	}
+}
+
+// InitValue returns the value returned by the WithInit function, nil if there is none.
+func InitValue(ctx context.Context) any {
+	return ctx.Value(initContextKey{})
+}
+
+// initialize runs the WithInit function unless it already succeeded, and adds its value and the resources to ctx.
+func (payloadOptions *PayloadOptions) initialize(ctx context.Context, inputs map[string]string) (context.Context, error) {
+	state := payloadOptions.Init
+	if state == nil {
+		return payloadOptions.withResources(ctx), nil
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if !state.done {
+		value, err := state.init(context.WithValue(ctx, resourcesContextKey{}, state.resources), inputs)
+		if err != nil {
+			return nil, fmt.Errorf("init failed: %w", err)
+		}
+		state.value, state.done = value, true
+	}
+
+	ctx = context.WithValue(ctx, initContextKey{}, state.value)
+	return payloadOptions.withResources(ctx), nil
+}
+
+// shutdownTimeout bounds the WithShutdown function, Lambda kills the environment about 500ms after sending SIGTERM.
+const shutdownTimeout = 400 * time.Millisecond
+
+// errShuttingDown fails the invocations starting once the WithShutdown function is about to run.
+var errShuttingDown = errors.New("the function is shutting down")
+
+// shutdownState keeps the WithShutdown function from running while invocations are processed.
+type shutdownState struct {
+	shutdown func(context.Context) error
+	mu       sync.Mutex
+	closed   bool
+	inFlight sync.WaitGroup
+}
+
+// WithShutdown runs shutdown when Lambda sends SIGTERM before shutting the environment down, e.g. to flush metrics or close connections.
+// shutdown gets a context bounded by the few hundred milliseconds Lambda leaves before killing the environment.
+// It never runs concurrently with an invocation: invocations starting once it is about to run fail,
+// and it waits for the ones in flight to return, being skipped if that doesn't happen before the context expires.
+// Errors are logged with the WithLogger logger.
+func WithShutdown(shutdown func(ctx context.Context) error) PayloadOption {
+	state := &shutdownState{shutdown: shutdown}
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.Shutdown = state
+		return nil
+	}
+}
+
+// beginInvocation holds the shutdown until the returned function is called at the end of the invocation,
+// it fails once the shutdown has begun.
+func (payloadOptions *PayloadOptions) beginInvocation() (func(), error) {
+	state := payloadOptions.Shutdown
+	if state == nil {
+		return func() {}, nil
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.closed {
+		return nil, errShuttingDown
+	}
+	state.inFlight.Add(1)
+	return state.inFlight.Done, nil
+}
+
+// run runs the shutdown function once no invocation is in flight.
+func (state *shutdownState) run(logger *slog.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	state.mu.Lock()
+	state.closed = true
+	state.mu.Unlock()
+
+	idle := make(chan struct{})
+	go func() {
+		state.inFlight.Wait()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		logger.Error("shutdown skipped, an invocation is still in flight")
+		return
+	}
+
+	if err := state.shutdown(ctx); err != nil {
+		logger.Error("shutdown failed", "error", err)
+	}
+}
@@ -0,0 +1,93 @@
+package functions_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go_template/functions"
+	"go_template/memphistest"
+)
+
+func TestShutdownDoesNotSerializeInvocations(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	var shutdowns int
+	function := functions.NewFunction(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		started <- struct{}{}
+		<-release
+		return payload, headers, nil
+	}, functions.WithShutdown(func(ctx context.Context) error {
+		shutdowns++
+		return nil
+	}))
+	event := memphistest.NewEvent().AddMessage([]byte("payload"), map[string]string{}).Build(t)
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Go(func() {
+			if _, err := function.Invoke(context.Background(), event); err != nil {
+				t.Errorf("invocation failed: %v", err)
+			}
+		})
+	}
+	for range 2 {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatal("the invocations didn't run concurrently")
+		}
+	}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		functions.RunShutdown(function)
+		close(shutdownDone)
+	}()
+	// wait for the shutdown to begin before checking new invocations are refused
+	for {
+		if functions.ShuttingDown(function) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := function.Invoke(context.Background(), event); !errors.Is(err, functions.ErrShuttingDown) {
+		t.Fatalf("invocation after the shutdown began returned %v, want %v", err, functions.ErrShuttingDown)
+	}
+	if shutdowns != 0 {
+		t.Fatal("the shutdown ran while invocations were in flight")
+	}
+
+	close(release)
+	wg.Wait()
+	<-shutdownDone
+	if shutdowns != 1 {
+		t.Fatalf("the shutdown ran %d times, want 1", shutdowns)
+	}
+}
+
+func TestShutdownSkippedWhileInvocationInFlight(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	shutdownRan := false
+	function := functions.NewFunction(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		close(started)
+		<-release
+		return payload, headers, nil
+	}, functions.WithShutdown(func(ctx context.Context) error {
+		shutdownRan = true
+		return nil
+	}))
+	event := memphistest.NewEvent().AddMessage([]byte("payload"), map[string]string{}).Build(t)
+
+	go function.Invoke(context.Background(), event)
+	<-started
+	functions.RunShutdown(function)
+	if shutdownRan {
+		t.Fatal("the shutdown ran while an invocation was in flight")
+	}
+}
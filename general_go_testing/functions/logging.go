@@ -0,0 +1,129 @@
+package functions
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+type loggerContextKey struct{}
+
+// WithLogger logs the processing of every message with logger, at Debug level for every message unless set otherwise
+// by WithMessageLogLevel or WithPerMessageLogging, at Error level when a message can't be decoded or its result can't be marshaled,
+// and a summary of every invocation at Info level.
+func WithLogger(logger *slog.Logger) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.Logger = logger
+		return nil
+	}
+}
+
+// Logger returns the logger of the message being processed, with the AWS request ID and the message index already attached.
+// It returns slog.Default() when no logger was set with WithLogger.
+func Logger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// WithPerMessageLogging turns the log line of every processed message on or off, it is on by default.
+// Turning it off keeps the summary of every invocation and the errors, for functions processing many messages.
+func WithPerMessageLogging(enabled bool) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.PerMessageLogging = enabled
+		return nil
+	}
+}
+
+// WithMessageLogLevel sets the level of the log line of every processed message, Debug by default.
+func WithMessageLogLevel(level slog.Level) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.MessageLogLevel = level
+		return nil
+	}
+}
+
+// messageLogger returns the logger of the message at index, or nil when logging isn't enabled.
+func (payloadOptions *PayloadOptions) messageLogger(ctx context.Context, index int) *slog.Logger {
+	if payloadOptions.Logger == nil {
+		return nil
+	}
+
+	return payloadOptions.invocationLogger(ctx).With("message_index", index)
+}
+
+// invocationLogger returns the logger of the invocation, with its AWS request ID attached.
+func (payloadOptions *PayloadOptions) invocationLogger(ctx context.Context) *slog.Logger {
+	logger := payloadOptions.Logger
+	if requestID, ok := awsRequestID(ctx); ok {
+		logger = logger.With("aws_request_id", requestID)
+	}
+	return logger
+}
+
+// logMessageResult logs the outcome of a message, failures to decode or marshal it are logged as errors.
+func (payloadOptions *PayloadOptions) logMessageResult(ctx context.Context, logger *slog.Logger, result messageResult) {
+	if logger == nil {
+		return
+	}
+
+	if result.failedMessage != nil {
+		switch result.failedMessage.Category {
+		case DecodeErrorCategory, MarshalErrorCategory:
+			logger.ErrorContext(ctx, "message failed", "category", result.failedMessage.Category, "error", result.failedMessage.Error)
+		}
+	}
+
+	if !payloadOptions.PerMessageLogging {
+		return
+	}
+	logger.Log(ctx, payloadOptions.MessageLogLevel, "message processed",
+		"decoded_size", result.decodedSize,
+		"handler_duration", result.handlerDuration,
+		"marshal_duration", result.encodeDuration,
+		"outcome", result.outcome(),
+		"duplicate", result.duplicate,
+	)
+}
+
+// logInvocation logs a summary of the results of an invocation that took duration.
+func (payloadOptions *PayloadOptions) logInvocation(ctx context.Context, results []messageResult, duration time.Duration) {
+	if payloadOptions.Logger == nil {
+		return
+	}
+
+	stats := newStats(results)
+	payloadOptions.invocationLogger(ctx).InfoContext(ctx, "invocation processed",
+		"messages", len(results),
+		"processed", stats.Processed,
+		"failed", stats.Failed,
+		"filtered", stats.Filtered,
+		"unprocessed", stats.Unprocessed,
+		"duplicates", stats.Duplicates,
+		"total_handler_time", stats.TotalHandlerTime,
+		"duration", duration,
+	)
+}
+
+// logDryRunFailures logs the messages that would have gone to the dead-letter station without the dry-run mode.
+func (payloadOptions *PayloadOptions) logDryRunFailures(ctx context.Context, results []messageResult) {
+	for i, result := range results {
+		if result.failedMessage != nil {
+			payloadOptions.logFailure(ctx, "dry run: message would have failed", i, result.failedMessage)
+		}
+	}
+}
+
+// logFailure logs at Warn level a failed message that doesn't go to the dead-letter station.
+func (payloadOptions *PayloadOptions) logFailure(ctx context.Context, msg string, index int, failedMessage *MemphisMsgWithError) {
+	if payloadOptions.Logger == nil {
+		return
+	}
+
+	payloadOptions.Logger.WarnContext(ctx, msg,
+		"message_index", index,
+		"category", failedMessage.Category,
+		"error", failedMessage.Error,
+	)
+}
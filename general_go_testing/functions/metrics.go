@@ -0,0 +1,51 @@
+package functions
+
+import "time"
+
+// MetricsRecorder records the outcome of every message, see the promrecorder package for a Prometheus implementation.
+// It is called from the workers processing the messages, so it must be safe for concurrent use with WithMaxConcurrency.
+type MetricsRecorder interface {
+	// IncProcessed counts n messages that emitted at least one message.
+	IncProcessed(n int)
+	// IncFailed counts n messages that failed.
+	IncFailed(n int)
+	// IncFiltered counts n messages that emitted none without failing.
+	IncFiltered(n int)
+	// ObserveHandlerDuration records how long the handler took for a message.
+	ObserveHandlerDuration(d time.Duration)
+}
+
+// noopMetricsRecorder is the MetricsRecorder used without WithMetricsRecorder.
+type noopMetricsRecorder struct{}
+
+func (noopMetricsRecorder) IncProcessed(int)                     {}
+func (noopMetricsRecorder) IncFailed(int)                        {}
+func (noopMetricsRecorder) IncFiltered(int)                      {}
+func (noopMetricsRecorder) ObserveHandlerDuration(time.Duration) {}
+
+// WithMetricsRecorder records the outcome of every message and the time its handler took with recorder.
+// Messages left unprocessed, to be retried, are not counted.
+func WithMetricsRecorder(recorder MetricsRecorder) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if recorder == nil {
+			recorder = noopMetricsRecorder{}
+		}
+		payloadOptions.MetricsRecorder = recorder
+		return nil
+	}
+}
+
+// recordMetrics records the result of a message with the MetricsRecorder.
+func (payloadOptions *PayloadOptions) recordMetrics(result messageResult) {
+	switch result.outcome() {
+	case emittedOutcome:
+		payloadOptions.MetricsRecorder.IncProcessed(1)
+	case filteredOutcome:
+		payloadOptions.MetricsRecorder.IncFiltered(1)
+	case failedOutcome:
+		payloadOptions.MetricsRecorder.IncFailed(1)
+	}
+	if result.handlerDuration > 0 {
+		payloadOptions.MetricsRecorder.ObserveHandlerDuration(result.handlerDuration)
+	}
+}
@@ -0,0 +1,31 @@
+package functions
+
+// FilterDecision is what a WithPreFilter predicate decides to do with a message.
+type FilterDecision int
+
+const (
+	// Keep processes the message as usual.
+	Keep FilterDecision = iota
+	// Filter drops the message from the station without decoding it.
+	Filter
+	// Passthrough emits the message unchanged without decoding it.
+	Passthrough
+)
+
+// WithPreFilter decides from the headers of every message, before its payload is decoded, whether to process it,
+// so the messages the function doesn't care about, e.g. of another event type, don't pay for the decoding.
+// predicate gets the headers of the message as is, it must not modify them.
+func WithPreFilter(predicate func(headers map[string]string) FilterDecision) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.PreFilter = predicate
+		return nil
+	}
+}
+
+// preFilter returns the decision of the WithPreFilter predicate for msg, Keep without one.
+func (payloadOptions *PayloadOptions) preFilter(msg MemphisMsg) FilterDecision {
+	if payloadOptions.PreFilter == nil {
+		return Keep
+	}
+	return payloadOptions.PreFilter(msg.Headers)
+}
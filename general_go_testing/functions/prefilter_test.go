@@ -0,0 +1,66 @@
+package functions_test
+
+import (
+	"context"
+	"testing"
+
+	"go_template/functions"
+	"go_template/memphistest"
+)
+
+func BenchmarkPreFilter(b *testing.B) {
+	items := make([]record, 32)
+	for i := range items {
+		items[i] = record{ID: i, Name: "name of the record"}
+	}
+	builder := memphistest.NewEvent()
+	for i := range 100 {
+		eventType := "other"
+		if i%10 == 0 {
+			eventType = "wanted"
+		}
+		builder.AddJSONMessage(map[string]any{"items": items}, map[string]string{"type": eventType})
+	}
+	event := builder.Build(b)
+
+	benchmarks := []struct {
+		name    string
+		handler functions.HandlerType
+		options []functions.PayloadOption
+	}{
+		{
+			name: "handler filter",
+			handler: func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				if headers["type"] != "wanted" {
+					return nil, nil, functions.ErrFilterMessage
+				}
+				return payload, headers, nil
+			},
+		},
+		{
+			name: "pre-filter",
+			handler: func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				return payload, headers, nil
+			},
+			options: []functions.PayloadOption{functions.WithPreFilter(func(headers map[string]string) functions.FilterDecision {
+				if headers["type"] != "wanted" {
+					return functions.Filter
+				}
+				return functions.Keep
+			})},
+		},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			options := append([]functions.PayloadOption{functions.PayloadInfo(nil, functions.JSON), functions.WithPerMessageLogging(false)}, bm.options...)
+			handler := functions.BuildHandler(bm.handler, options...)
+			b.ReportAllocs()
+			for b.Loop() {
+				out, err := handler(context.Background(), event)
+				if err != nil || len(out.Messages) != 10 {
+					b.Fatalf("invocation failed: %v, %+v", err, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package functions
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// ProtoMessageInput is the input naming the full name of the message of the WithProtoDescriptorSet descriptors payloads are decoded into.
+const ProtoMessageInput = "proto_message"
+
+// WithProtoDescriptorSet decodes PROTOBUF payloads into a dynamic message of the type named by the proto_message input,
+// looked up in the files of set. Handlers get a *dynamicpb.Message, which can be modified generically through its ProtoReflect view.
+func WithProtoDescriptorSet(set *descriptorpb.FileDescriptorSet) PayloadOption {
+	files, err := protodesc.NewFiles(set)
+	return func(payloadOptions *PayloadOptions) error {
+		if err != nil {
+			return fmt.Errorf("invalid proto descriptor set: %w", err)
+		}
+		payloadOptions.ProtoFiles = files
+		return nil
+	}
+}
+
+// resolveProtoMessage sets the user schema to the descriptor of the message named by the proto_message input.
+func (payloadOptions *PayloadOptions) resolveProtoMessage(inputs map[string]string) error {
+	if payloadOptions.ProtoFiles == nil {
+		return nil
+	}
+
+	name, ok := inputs[ProtoMessageInput]
+	if !ok {
+		return fmt.Errorf("missing required inputs: %s", ProtoMessageInput)
+	}
+	descriptor, err := payloadOptions.ProtoFiles.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return fmt.Errorf("couldn't find proto message %s: %w", name, err)
+	}
+	messageDescriptor, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return fmt.Errorf("%s is not a proto message", name)
+	}
+
+	payloadOptions.UserObject = messageDescriptor
+	return nil
+}
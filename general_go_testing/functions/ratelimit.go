@@ -0,0 +1,37 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/time/rate"
+)
+
+// errDeadlineNotProcessed fails the messages that can't be processed before the deadline of the invocation.
+var errDeadlineNotProcessed = errors.New("not processed: deadline")
+
+// WithRateLimit calls the handler at most rps times per second, with bursts of up to burst calls,
+// across all the invocations of the Lambda instance and the workers of WithMaxConcurrency.
+// Messages that would have to wait past the deadline of the invocation are returned as UnprocessedMessages.
+func WithRateLimit(rps float64, burst int) PayloadOption {
+	limiter := rate.NewLimiter(rate.Limit(rps), burst)
+	return func(payloadOptions *PayloadOptions) error {
+		if rps <= 0 || burst <= 0 {
+			return fmt.Errorf("rate limit must be positive, got %v rps with a burst of %d", rps, burst)
+		}
+		payloadOptions.RateLimiter = limiter
+		return nil
+	}
+}
+
+// waitRateLimit waits until the handler may be called, or fails if that would be past the deadline of ctx.
+func (payloadOptions *PayloadOptions) waitRateLimit(ctx context.Context) error {
+	if payloadOptions.RateLimiter == nil {
+		return nil
+	}
+	if err := payloadOptions.RateLimiter.Wait(ctx); err != nil {
+		return errDeadlineNotProcessed
+	}
+	return nil
+}
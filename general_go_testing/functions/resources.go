@@ -0,0 +1,83 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"reflect"
+	"sync"
+)
+
+type resourcesContextKey struct{}
+
+// resourceSet holds the resources handlers get with Resource.
+type resourceSet struct {
+	mu     sync.Mutex
+	values map[string]any
+}
+
+// WithResource makes value available to handlers as the resource key, e.g. to inject fakes in tests.
+// It takes precedence over a resource of the same key set by the WithInit function.
+func WithResource(key string, value any) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if payloadOptions.Resources == nil {
+			payloadOptions.Resources = map[string]any{}
+		}
+		payloadOptions.Resources[key] = value
+		return nil
+	}
+}
+
+// SetResource makes value available to handlers as the resource key, it is meant to be called by the WithInit function
+// with the context it gets, so that the resources it creates are kept for the following invocations.
+func SetResource(ctx context.Context, key string, value any) error {
+	set, ok := ctx.Value(resourcesContextKey{}).(*resourceSet)
+	if !ok {
+		return fmt.Errorf("no resources in context to set %q in", key)
+	}
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.values[key] = value
+	return nil
+}
+
+// Resource returns the resource key set with WithResource or SetResource,
+// it fails when the resource is missing or isn't a T.
+func Resource[T any](ctx context.Context, key string) (T, error) {
+	var zero T
+	set, ok := ctx.Value(resourcesContextKey{}).(*resourceSet)
+	if !ok {
+		return zero, fmt.Errorf("resource %q not found", key)
+	}
+	set.mu.Lock()
+	value, ok := set.values[key]
+	set.mu.Unlock()
+	if !ok {
+		return zero, fmt.Errorf("resource %q not found", key)
+	}
+	typed, ok := value.(T)
+	if !ok {
+		return zero, fmt.Errorf("resource %q is a %T, not a %v", key, value, reflect.TypeFor[T]())
+	}
+	return typed, nil
+}
+
+// ContextWithResources returns ctx with the resources handlers get with Resource, to call a HandlerWithContextType directly.
+// The memphistest package wraps it for tests.
+func ContextWithResources(ctx context.Context, resources map[string]any) context.Context {
+	set := &resourceSet{values: map[string]any{}}
+	maps.Copy(set.values, resources)
+	return context.WithValue(ctx, resourcesContextKey{}, set)
+}
+
+// withResources adds the resources set by the WithInit function and by WithResource to ctx.
+func (payloadOptions *PayloadOptions) withResources(ctx context.Context) context.Context {
+	set := &resourceSet{values: map[string]any{}}
+	if payloadOptions.Init != nil {
+		payloadOptions.Init.resources.mu.Lock()
+		maps.Copy(set.values, payloadOptions.Init.resources.values)
+		payloadOptions.Init.resources.mu.Unlock()
+	}
+	maps.Copy(set.values, payloadOptions.Resources)
+	return context.WithValue(ctx, resourcesContextKey{}, set)
+}
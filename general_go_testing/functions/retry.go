@@ -0,0 +1,111 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// BackoffFunc returns how long to wait before retrying a handler call that failed for the attempt-th time, starting at 1.
+type BackoffFunc func(attempt int) time.Duration
+
+// ConstantBackoff waits d before every retry.
+func ConstantBackoff(d time.Duration) BackoffFunc {
+	return func(int) time.Duration { return d }
+}
+
+// ExponentialBackoff waits base before the first retry and doubles the wait for every following one, up to max.
+func ExponentialBackoff(base, max time.Duration) BackoffFunc {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		return min(d, max)
+	}
+}
+
+// WithRetry calls the handler up to attempts times for a message while it fails with a retryable error,
+// waiting backoff between the calls, a nil backoff retries right away.
+// Errors are retryable when wrapped with Retryable, or unclassified with a retryable WithDefaultErrorClassification,
+// WithRetryIf replaces that check. A message still failing is reported with the last error and the number of attempts.
+// Retries stop early rather than run past the MessageTimeout, which bounds all the attempts of a message, or the deadline of the invocation.
+// The handler gets the same decoded message on every attempt, so it shouldn't rely on mutating it.
+func WithRetry(attempts int, backoff BackoffFunc) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if attempts <= 0 {
+			return fmt.Errorf("retry attempts must be positive, got %d", attempts)
+		}
+		payloadOptions.RetryAttempts = attempts
+		payloadOptions.RetryBackoff = backoff
+		return nil
+	}
+}
+
+// WithRetryIf retries the handler errors for which retryable returns true instead of the ones classified as retryable.
+// It has no effect without WithRetry.
+func WithRetryIf(retryable func(error) bool) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.RetryIf = retryable
+		return nil
+	}
+}
+
+// retryable tells whether a handler call that failed with err should be retried.
+func (payloadOptions *PayloadOptions) retryable(err error) bool {
+	if payloadOptions.RetryIf != nil {
+		return payloadOptions.RetryIf(err)
+	}
+	classification := Classification(err)
+	if classification == Unclassified {
+		classification = payloadOptions.DefaultErrorClassification
+	}
+	return classification == RetryableClassification
+}
+
+// callHandlerWithRetry calls the handler, retrying it as set by WithRetry.
+func (payloadOptions *PayloadOptions) callHandlerWithRetry(ctx context.Context, message any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+	// every attempt gets its own copy of the headers, so a failed one doesn't leak its changes into the next
+	if payloadOptions.RetryAttempts <= 1 {
+		return payloadOptions.callHandler(ctx, message, copyHeaders(headers), inputs)
+	}
+
+	invocationCtx := ctx
+	if payloadOptions.MessageTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, payloadOptions.MessageTimeout)
+		defer cancel()
+	}
+
+	for attempt := 1; ; attempt++ {
+		modifiedPayload, modifiedHeaders, err := payloadOptions.callHandler(ctx, message, copyHeaders(headers), inputs)
+		if err != nil && ctx.Err() != nil && invocationCtx.Err() == nil && errors.Is(err, ctx.Err()) {
+			err = fmt.Errorf("handler timeout after %v", payloadOptions.MessageTimeout)
+		}
+		if err == nil || !payloadOptions.retryable(err) {
+			return modifiedPayload, modifiedHeaders, err
+		}
+		if attempt == payloadOptions.RetryAttempts {
+			return nil, nil, fmt.Errorf("failed after %d attempts: %w", attempt, err)
+		}
+
+		var wait time.Duration
+		if payloadOptions.RetryBackoff != nil {
+			wait = payloadOptions.RetryBackoff(attempt)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+			return nil, nil, fmt.Errorf("failed after %d attempts, no time left to retry: %w", attempt, err)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil, fmt.Errorf("failed after %d attempts, no time left to retry: %w", attempt, err)
+		}
+		if err := payloadOptions.waitRateLimit(ctx); err != nil {
+			return nil, nil, fmt.Errorf("failed after %d attempts, no time left to retry: %w", attempt, err)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package functions
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// UnmatchedPolicy is what a Router does with the messages no route matches when it has no default handler.
+type UnmatchedPolicy int
+
+const (
+	// FailUnmatched fails the message, it goes into the dead-letter station.
+	FailUnmatched UnmatchedPolicy = iota
+	// FilterUnmatched filters the message out of the station.
+	FilterUnmatched
+	// PassthroughUnmatched emits the message unchanged.
+	PassthroughUnmatched
+)
+
+// Router dispatches every message to the handler of the first route whose header has the route's value,
+// so a single function can handle several event types of a station:
+//
+//	router := NewRouter()
+//	router.Handle("event-type", "order.created", createdHandler)
+//	router.Handle("event-type", "order.canceled", canceledHandler, PayloadInfo(&Cancellation{}, JSON))
+//	CreateFunction(router.Handler())
+//
+// A route registered with PayloadInfo or AvroSchema decodes the payload itself into its own schema,
+// which needs the function to leave the payload as []byte, i.e. to be created without PayloadInfo.
+// What such a route returns is marshaled with its own schema too, and emitted as is by the function.
+// Routes without options get the payload as decoded by the function.
+type Router struct {
+	routes       []route
+	defaultRoute *route
+	unmatched    UnmatchedPolicy
+}
+
+type route struct {
+	header  string
+	value   string
+	handler HandlerType
+	// payloadOptions decode the payload of the route, nil when it uses the payload decoded by the function
+	payloadOptions *PayloadOptions
+}
+
+// NewRouter returns a Router without routes, which fails the unmatched messages.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle routes the messages whose header has value to handler, decoding their payload with options if any.
+// It panics if options are invalid, like registering an invalid route on an http.ServeMux.
+func (router *Router) Handle(header string, value string, handler HandlerType, options ...PayloadOption) {
+	router.routes = append(router.routes, newRoute(header, value, handler, options))
+}
+
+// Default routes the messages no route matches to handler, decoding their payload with options if any.
+func (router *Router) Default(handler HandlerType, options ...PayloadOption) {
+	defaultRoute := newRoute("", "", handler, options)
+	router.defaultRoute = &defaultRoute
+}
+
+// Unmatched sets what happens to the messages no route matches when there is no default handler, they fail by default.
+func (router *Router) Unmatched(policy UnmatchedPolicy) {
+	router.unmatched = policy
+}
+
+func newRoute(header string, value string, handler HandlerType, options []PayloadOption) route {
+	if handler == nil {
+		panic(fmt.Sprintf("invalid route %s=%s: nil handler", header, value))
+	}
+	r := route{header: header, value: value, handler: handler}
+	if len(options) > 0 {
+		payloadOptions, err := newPayloadOptions(nil, options)
+		if err != nil {
+			panic(fmt.Sprintf("invalid route %s=%s: %v", header, value, err))
+		}
+		r.payloadOptions = payloadOptions
+	}
+	return r
+}
+
+// Handler returns the handler to pass to CreateFunction.
+// Routes shouldn't be added once it is called.
+func (router *Router) Handler() HandlerType {
+	return func(message any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		r := router.match(headers)
+		if r == nil {
+			return router.unmatchedResult(headers)
+		}
+
+		if r.payloadOptions != nil {
+			payload, ok := message.([]byte)
+			if !ok {
+				return nil, nil, fmt.Errorf("route %s=%s decodes its own payload but the function decoded it into %T", r.header, r.value, message)
+			}
+			payload, err := r.payloadOptions.validateUTF8(payload)
+			if err != nil {
+				return nil, nil, Permanent(err)
+			}
+			decoded, err := r.payloadOptions.decodeInput(payload)
+			if err != nil {
+				return nil, nil, Permanent(err)
+			}
+			message = decoded
+
+			modifiedPayload, modifiedHeaders, err := r.handler(message, headers, inputs)
+			if err != nil {
+				return nil, nil, err
+			}
+			if modifiedPayload, err = r.marshalOutput(modifiedPayload); err != nil {
+				return nil, nil, Permanent(err)
+			}
+			return modifiedPayload, modifiedHeaders, nil
+		}
+
+		return r.handler(message, headers, inputs)
+	}
+}
+
+// marshalOutput marshals what the handler of a route decoding its own payload returns with the route's serializer,
+// so the function emits the resulting []byte as is rather than marshaling it with its own serializer.
+func (r *route) marshalOutput(payload any) (any, error) {
+	switch payload := payload.(type) {
+	case nil, []byte, json.RawMessage:
+		return payload, nil
+	case []OutMsg:
+		marshaled := make([]OutMsg, len(payload))
+		for i, outMsg := range payload {
+			var err error
+			if outMsg.Payload, err = r.marshalOutput(outMsg.Payload); err != nil {
+				return nil, err
+			}
+			marshaled[i] = outMsg
+		}
+		return marshaled, nil
+	default:
+		marshaled, err := r.payloadOptions.Serializer.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("route %s=%s couldn't marshal its output: %w", r.header, r.value, err)
+		}
+		return marshaled, nil
+	}
+}
+
+// match returns the route of a message with headers, nil when there is none.
+func (router *Router) match(headers map[string]string) *route {
+	for i := range router.routes {
+		r := &router.routes[i]
+		if value, ok := Headers(headers).lookup(r.header); ok && value == r.value {
+			return r
+		}
+	}
+	return router.defaultRoute
+}
+
+func (router *Router) unmatchedResult(headers map[string]string) (any, map[string]string, error) {
+	switch router.unmatched {
+	case FilterUnmatched:
+		return nil, nil, ErrFilterMessage
+	case PassthroughUnmatched:
+		if headers == nil {
+			headers = map[string]string{} // nil headers would filter the message
+		}
+		return nil, headers, nil
+	default:
+		return nil, nil, Permanent(fmt.Errorf("no route matches the message"))
+	}
+}
@@ -0,0 +1,164 @@
+package functions
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/hamba/avro/v2"
+)
+
+// SchemaIDHeader is the header holding the registry id of the schema of a message.
+const SchemaIDHeader = "schema-id"
+
+// maxCachedSchemas bounds the number of registry schemas kept, the least recently used ones are evicted first.
+const maxCachedSchemas = 256
+
+// RegistrySchema is a schema as returned by a schema registry, Type is AVRO or JSON, an empty Type meaning AVRO.
+type RegistrySchema struct {
+	Type   string
+	Schema string
+}
+
+// RegistryClient fetches schemas from a schema registry by id.
+type RegistryClient interface {
+	GetSchema(ctx context.Context, id string) (RegistrySchema, error)
+}
+
+// WithSchemaRegistry decodes the payloads of the messages with a schema-id header with the schema client returns for that id,
+// AVRO and JSON schemas being supported, and marshals what the handler returns with the same schema.
+// Payloads are decoded into the user schema, or into a map[string]any without one. Schemas are cached,
+// messages whose schema can't be fetched are failed as retryable.
+func WithSchemaRegistry(client RegistryClient) PayloadOption {
+	registry := &schemaRegistry{client: client, entries: map[string]*list.Element{}, lru: list.New()}
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.SchemaRegistry = registry
+		return nil
+	}
+}
+
+// schemaRegistry caches the serializers of the registry schemas across invocations.
+type schemaRegistry struct {
+	client RegistryClient
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type cachedSchema struct {
+	id         string
+	serializer Serializer
+}
+
+// serializer returns the serializer of the schema id, fetching it from the registry on a cache miss.
+func (registry *schemaRegistry) serializer(ctx context.Context, id string) (Serializer, error) {
+	registry.mu.Lock()
+	if element, ok := registry.entries[id]; ok {
+		registry.lru.MoveToFront(element)
+		registry.mu.Unlock()
+		return element.Value.(*cachedSchema).serializer, nil
+	}
+	registry.mu.Unlock()
+
+	schema, err := registry.client.GetSchema(ctx, id)
+	if err != nil {
+		return nil, Retryable(fmt.Errorf("couldn't fetch schema %s: %w", id, err))
+	}
+	serializer, err := newRegistrySerializer(schema)
+	if err != nil {
+		return nil, Permanent(fmt.Errorf("invalid schema %s: %w", id, err))
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if _, ok := registry.entries[id]; !ok {
+		registry.entries[id] = registry.lru.PushFront(&cachedSchema{id: id, serializer: serializer})
+		if registry.lru.Len() > maxCachedSchemas {
+			oldest := registry.lru.Back()
+			registry.lru.Remove(oldest)
+			delete(registry.entries, oldest.Value.(*cachedSchema).id)
+		}
+	}
+
+	return serializer, nil
+}
+
+func newRegistrySerializer(schema RegistrySchema) (Serializer, error) {
+	switch strings.ToUpper(schema.Type) {
+	case "", "AVRO":
+		avroSchema, err := avro.Parse(schema.Schema)
+		if err != nil {
+			return nil, err
+		}
+		return avroSerializer{schema: avroSchema}, nil
+	case "JSON":
+		jsonSchema, err := compileJSONSchema(schema.Schema)
+		if err != nil {
+			return nil, err
+		}
+		return jsonSchemaSerializer{schema: jsonSchema}, nil
+	default:
+		return nil, fmt.Errorf("unsupported schema type %s", schema.Type)
+	}
+}
+
+// registrySerializer returns the serializer of the schema of a message with the given headers,
+// nil when it has no schema-id header or no schema registry is used.
+func (payloadOptions *PayloadOptions) registrySerializer(ctx context.Context, headers map[string]string) (Serializer, error) {
+	if payloadOptions.SchemaRegistry == nil {
+		return nil, nil
+	}
+	id, ok := Headers(headers).lookup(SchemaIDHeader)
+	if !ok {
+		return nil, nil
+	}
+	return payloadOptions.SchemaRegistry.serializer(ctx, id)
+}
+
+// RESTRegistryClient is a RegistryClient for registries with a Confluent compatible REST API.
+type RESTRegistryClient struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewRESTRegistryClient returns a client of the registry at baseURL, httpClient defaults to http.DefaultClient.
+func NewRESTRegistryClient(baseURL string, httpClient *http.Client) *RESTRegistryClient {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &RESTRegistryClient{baseURL: strings.TrimSuffix(baseURL, "/"), httpClient: httpClient}
+}
+
+// GetSchema fetches the schema id with GET /schemas/ids/{id}.
+func (c *RESTRegistryClient) GetSchema(ctx context.Context, id string) (RegistrySchema, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/schemas/ids/"+url.PathEscape(id), nil)
+	if err != nil {
+		return RegistrySchema{}, err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return RegistrySchema{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return RegistrySchema{}, fmt.Errorf("schema registry returned %s", resp.Status)
+	}
+
+	var body struct {
+		Schema     string `json:"schema"`
+		SchemaType string `json:"schemaType"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return RegistrySchema{}, err
+	}
+
+	return RegistrySchema{Type: body.SchemaType, Schema: body.Schema}, nil
+}
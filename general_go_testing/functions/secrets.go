@@ -0,0 +1,108 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretResolver resolves the secret name refers to, e.g. the name of an SSM parameter or of a Secrets Manager secret.
+// The awssecrets package implements it for both.
+type SecretResolver interface {
+	Resolve(ctx context.Context, name string) (string, error)
+}
+
+// secretSource resolves the secrets of a scheme, caching them for ttl.
+type secretSource struct {
+	resolver SecretResolver
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedSecret
+}
+
+type cachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+// WithSecretResolver replaces the inputs whose value is a reference to a secret, like "ssm:/myapp/api-key" for the "ssm" scheme,
+// with the secret resolved by resolver before they reach the handler, WithInputs or WithConfig, so secrets aren't stored as plain inputs.
+// Secrets are resolved by the first invocation that needs them and cached for ttl, or for the lifetime of the Lambda instance when ttl is 0.
+// An invocation fails when one of its secrets can't be resolved. Resolved secrets are never logged.
+func WithSecretResolver(scheme string, resolver SecretResolver, ttl time.Duration) PayloadOption {
+	source := &secretSource{resolver: resolver, ttl: ttl, cache: make(map[string]cachedSecret)}
+	return func(payloadOptions *PayloadOptions) error {
+		if scheme == "" || strings.Contains(scheme, ":") {
+			return fmt.Errorf("invalid secret scheme %q", scheme)
+		}
+		if resolver == nil {
+			return fmt.Errorf("secret resolver of scheme %q can't be nil", scheme)
+		}
+		if ttl < 0 {
+			return fmt.Errorf("secret cache ttl can't be negative: %v", ttl)
+		}
+		if payloadOptions.SecretSources == nil {
+			payloadOptions.SecretSources = map[string]*secretSource{}
+		}
+		payloadOptions.SecretSources[scheme] = source
+		return nil
+	}
+}
+
+// resolveSecrets returns the inputs with the secret references replaced by their values, or inputs as is when there are none.
+func (payloadOptions *PayloadOptions) resolveSecrets(ctx context.Context, inputs map[string]string) (map[string]string, error) {
+	if payloadOptions.SecretSources == nil {
+		return inputs, nil
+	}
+
+	var resolved map[string]string
+	for name, value := range inputs {
+		scheme, secretName, ok := strings.Cut(value, ":")
+		source := payloadOptions.SecretSources[scheme]
+		if !ok || source == nil {
+			continue
+		}
+
+		secret, err := source.resolve(ctx, secretName)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't resolve the secret %s of input %s: %w", value, name, err)
+		}
+		if resolved == nil {
+			resolved = maps.Clone(inputs)
+		}
+		resolved[name] = secret
+	}
+
+	if resolved == nil {
+		return inputs, nil
+	}
+	return resolved, nil
+}
+
+// resolve returns the secret name, from the cache unless it expired.
+func (source *secretSource) resolve(ctx context.Context, name string) (string, error) {
+	source.mu.Lock()
+	cached, ok := source.cache[name]
+	source.mu.Unlock()
+	if ok && (cached.expires.IsZero() || time.Now().Before(cached.expires)) {
+		return cached.value, nil
+	}
+
+	value, err := source.resolver.Resolve(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	cached = cachedSecret{value: value}
+	if source.ttl > 0 {
+		cached.expires = time.Now().Add(source.ttl)
+	}
+	source.mu.Lock()
+	source.cache[name] = cached
+	source.mu.Unlock()
+	return value, nil
+}
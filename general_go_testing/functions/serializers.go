@@ -0,0 +1,349 @@
+package functions
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"unicode/utf8"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/hamba/avro/v2"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// Serializer decodes payloads into the user schema and encodes the payloads returned by handlers.
+// Every PayloadType has a built-in Serializer, WithSerializer replaces it with a custom one.
+type Serializer interface {
+	Marshal(any) ([]byte, error)
+	Unmarshal([]byte, any) error
+}
+
+// WithSerializer uses s to unmarshal payloads into the user schema and to marshal the handler's results,
+// instead of the built-in serializer of the PayloadType.
+func WithSerializer(s Serializer) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.Serializer = s
+		return nil
+	}
+}
+
+// defaultSerializer returns the built-in Serializer of the PayloadType, JSON is used for unknown types.
+// The output is always encoded by the same Serializer the input was decoded with.
+func defaultSerializer(payloadOptions *PayloadOptions) Serializer {
+	switch payloadOptions.PayloadType {
+	case BYTES:
+		return bytesSerializer{decoding: payloadOptions.jsonDecoding(), encoding: payloadOptions.JSONEncoding}
+	case PROTOBUF:
+		return protobufSerializer{}
+	case AVRO:
+		return avroSerializer{schema: payloadOptions.AvroSchema}
+	case MSGPACK:
+		return msgpackSerializer{}
+	case CBOR:
+		return cborSerializer{}
+	case XML:
+		return xmlSerializer{}
+	case YAML:
+		return yamlSerializer{}
+	case TEXT:
+		return textSerializer{}
+	case GOB:
+		return gobSerializer{}
+	case FLATBUFFERS:
+		return flatbuffersSerializer{}
+	}
+
+	return jsonSerializer{decoding: payloadOptions.jsonDecoding(), encoding: payloadOptions.JSONEncoding}
+}
+
+type jsonSerializer struct {
+	decoding jsonDecoding
+	encoding *JSONEncodeOptions
+}
+
+func (s jsonSerializer) Marshal(v any) ([]byte, error) {
+	return s.encoding.marshal(v)
+}
+
+func (s jsonSerializer) Unmarshal(data []byte, v any) error {
+	return s.decoding.unmarshal(data, v)
+}
+
+// bytesSerializer is used when handlers decode the []byte payload themselves,
+// results they built with protobuf are encoded back with protobuf and everything else as JSON.
+type bytesSerializer struct {
+	decoding jsonDecoding
+	encoding *JSONEncodeOptions
+}
+
+func (s bytesSerializer) Marshal(v any) ([]byte, error) {
+	if protoMessage, ok := v.(proto.Message); ok {
+		return proto.Marshal(protoMessage)
+	}
+	return s.encoding.marshal(v)
+}
+
+func (s bytesSerializer) Unmarshal(data []byte, v any) error {
+	return s.decoding.unmarshal(data, v)
+}
+
+// WithStrictJSON fails the JSON payloads with fields the user schema doesn't have, naming the unknown field,
+// instead of ignoring those fields.
+func WithStrictJSON() PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.StrictJSON = true
+		return nil
+	}
+}
+
+// JSONEncodeOptions tells how the handler's results are marshaled to JSON, its zero value doesn't escape HTML,
+// doesn't indent and doesn't end the payload with a newline.
+type JSONEncodeOptions struct {
+	// EscapeHTML escapes <, > and & as \u003c, \u003e and \u0026 like json.Marshal does
+	EscapeHTML bool
+	// Prefix and Indent indent the payload like json.MarshalIndent does when Indent isn't empty
+	Prefix string
+	Indent string
+	// TrailingNewline keeps the newline a json.Encoder ends every value with
+	TrailingNewline bool
+}
+
+// WithJSONEncoder marshals the handler's results to JSON according to opts instead of with json.Marshal.
+func WithJSONEncoder(opts JSONEncodeOptions) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.JSONEncoding = &opts
+		return nil
+	}
+}
+
+// marshal marshals v according to opts, with json.Marshal when opts is nil.
+func (opts *JSONEncodeOptions) marshal(v any) ([]byte, error) {
+	if opts == nil {
+		return json.Marshal(v)
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(opts.EscapeHTML)
+	if opts.Indent != "" {
+		encoder.SetIndent(opts.Prefix, opts.Indent)
+	}
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+
+	data := buf.Bytes()
+	if !opts.TrailingNewline {
+		data = bytes.TrimSuffix(data, []byte("\n"))
+	}
+	return data, nil
+}
+
+// WithJSONNumbers decodes the numbers of JSON payloads into json.Number instead of float64 in maps and interfaces,
+// so that large integers such as 64-bit IDs are emitted back exactly as they were received.
+func WithJSONNumbers() PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.JSONNumbers = true
+		return nil
+	}
+}
+
+// jsonDecoding tells how the built-in serializers unmarshal JSON payloads.
+type jsonDecoding struct {
+	strict    bool
+	useNumber bool
+}
+
+func (payloadOptions *PayloadOptions) jsonDecoding() jsonDecoding {
+	return jsonDecoding{strict: payloadOptions.StrictJSON, useNumber: payloadOptions.JSONNumbers}
+}
+
+func (d jsonDecoding) unmarshal(data []byte, v any) error {
+	if !d.strict && !d.useNumber {
+		return UnmarshalIntoStruct(data, v)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if d.strict {
+		decoder.DisallowUnknownFields()
+	}
+	if d.useNumber {
+		decoder.UseNumber()
+	}
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid character after top-level value")
+	}
+	return nil
+}
+
+type protobufSerializer struct{}
+
+func (protobufSerializer) Marshal(v any) ([]byte, error) {
+	protoMessage, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("returned payload of type %v doesn't implement proto.Message", reflect.TypeOf(v))
+	}
+	return proto.Marshal(protoMessage)
+}
+
+func (protobufSerializer) Unmarshal(data []byte, v any) error {
+	protoMessage, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("schema of type %v doesn't implement proto.Message", reflect.TypeOf(v))
+	}
+	return proto.Unmarshal(data, protoMessage)
+}
+
+type avroSerializer struct {
+	schema avro.Schema
+}
+
+func (s avroSerializer) Marshal(v any) ([]byte, error) {
+	return avro.Marshal(s.schema, v)
+}
+
+func (s avroSerializer) Unmarshal(data []byte, v any) error {
+	return avro.Unmarshal(s.schema, data, v)
+}
+
+type msgpackSerializer struct{}
+
+func (msgpackSerializer) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackSerializer) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type cborSerializer struct{}
+
+func (cborSerializer) Marshal(v any) ([]byte, error) {
+	return cbor.Marshal(v)
+}
+
+// Unmarshal accepts indefinite-length items, maps with non-string keys only decode into map[any]any.
+func (cborSerializer) Unmarshal(data []byte, v any) error {
+	return cbor.Unmarshal(data, v)
+}
+
+type xmlSerializer struct{}
+
+// Marshal takes the root element name from the XMLName field or the type name, and adds the declaration back.
+func (xmlSerializer) Marshal(v any) ([]byte, error) {
+	marshaled, err := xml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), marshaled...), nil
+}
+
+// Unmarshal returns syntax errors as *xml.SyntaxError, which already carry the line of the malformed input.
+func (xmlSerializer) Unmarshal(data []byte, v any) error {
+	return xml.Unmarshal(data, v)
+}
+
+type yamlSerializer struct{}
+
+func (yamlSerializer) Marshal(v any) ([]byte, error) {
+	return yaml.Marshal(v)
+}
+
+// Unmarshal decodes a single YAML document, payloads with more than one (--- separated) document are rejected.
+func (yamlSerializer) Unmarshal(data []byte, v any) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+
+	var next yaml.Node
+	if err := decoder.Decode(&next); !errors.Is(err, io.EOF) {
+		return fmt.Errorf("multi-document YAML payloads are not supported")
+	}
+
+	return nil
+}
+
+type textSerializer struct{}
+
+func (textSerializer) Marshal(v any) ([]byte, error) {
+	text, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("TEXT handlers must return a string, got %v", reflect.TypeOf(v))
+	}
+	if !utf8.ValidString(text) {
+		return nil, fmt.Errorf("returned string is not valid UTF-8")
+	}
+	return []byte(text), nil
+}
+
+func (textSerializer) Unmarshal(data []byte, v any) error {
+	text, ok := v.(*string)
+	if !ok {
+		return fmt.Errorf("TEXT payloads can only be unmarshaled into a *string, got %v", reflect.TypeOf(v))
+	}
+	if !utf8.Valid(data) {
+		return fmt.Errorf("payload is not valid UTF-8")
+	}
+	*text = string(data)
+	return nil
+}
+
+type gobSerializer struct{}
+
+func (gobSerializer) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobSerializer) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// flatbuffersSerializer only rejects results that aren't buffers, buffers are passed through as []byte without serialization.
+type flatbuffersSerializer struct{}
+
+func (flatbuffersSerializer) Marshal(v any) ([]byte, error) {
+	return nil, fmt.Errorf("FLATBUFFERS handlers must return the buffer as []byte, got %v", reflect.TypeOf(v))
+}
+
+func (flatbuffersSerializer) Unmarshal(data []byte, v any) error {
+	return fmt.Errorf("FLATBUFFERS payloads are passed to the handler as []byte")
+}
+
+// jsonSchemaSerializer is the JSON serializer validating payloads against a JSON schema both ways.
+type jsonSchemaSerializer struct {
+	schema *jsonschema.Schema
+}
+
+func (s jsonSchemaSerializer) Marshal(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateJSONSchema(s.schema, data); err != nil {
+		return nil, fmt.Errorf("schema violation: %w", err)
+	}
+	return data, nil
+}
+
+func (s jsonSchemaSerializer) Unmarshal(data []byte, v any) error {
+	if err := validateJSONSchema(s.schema, data); err != nil {
+		return fmt.Errorf("schema violation: %w", err)
+	}
+	return json.Unmarshal(data, v)
+}
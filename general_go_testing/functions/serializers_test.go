@@ -0,0 +1,206 @@
+package functions_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/hamba/avro/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"gopkg.in/yaml.v3"
+
+	"go_template/functions"
+	"go_template/memphistest"
+)
+
+func TestYAMLMultiDocumentRejected(t *testing.T) {
+	handler := functions.BuildHandler(echo, functions.PayloadInfo(&record{}, functions.YAML))
+	event := memphistest.NewEvent().
+		AddMessage([]byte("id: 1\nname: first\n"), nil).
+		AddMessage([]byte("id: 1\n---\nid: 2\n"), nil).
+		Build(t)
+
+	out, err := handler(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 1 {
+		t.Fatalf("got %d emitted messages, want the single document one", len(out.Messages))
+	}
+	memphistest.RequireFailed(t, out, 0, "multi-document YAML payloads are not supported")
+}
+
+// event is a payload every built-in serializer can round trip.
+type event struct {
+	ID   int    `json:"id" avro:"id" msgpack:"id" cbor:"id" xml:"id" yaml:"id"`
+	Name string `json:"name" avro:"name" msgpack:"name" cbor:"name" xml:"name" yaml:"name"`
+}
+
+const eventAvroSchema = `{"type":"record","name":"event","fields":[{"name":"id","type":"int"},{"name":"name","type":"string"}]}`
+
+func TestSerializersRoundTrip(t *testing.T) {
+	value := event{ID: 42, Name: "événement"}
+	marshal := func(marshal func() ([]byte, error)) []byte {
+		t.Helper()
+		data, err := marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	xmlPayload := append([]byte(xml.Header), marshal(func() ([]byte, error) { return xml.Marshal(value) })...)
+	var gobPayload bytes.Buffer
+	if err := gob.NewEncoder(&gobPayload).Encode(value); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		payload []byte
+		options []functions.PayloadOption
+	}{
+		{name: "bytes", payload: []byte{0, 1, 2, 0xff}},
+		{name: "json", payload: marshal(func() ([]byte, error) { return json.Marshal(value) }), options: []functions.PayloadOption{functions.PayloadInfo(&event{}, functions.JSON)}},
+		{name: "json without schema", payload: []byte(`{"id":42,"name":"événement"}`), options: []functions.PayloadOption{functions.PayloadInfo(nil, functions.JSON)}},
+		{name: "protobuf", payload: marshal(func() ([]byte, error) { return proto.Marshal(wrapperspb.String("événement")) }), options: []functions.PayloadOption{functions.PayloadInfo(&wrapperspb.StringValue{}, functions.PROTOBUF)}},
+		{name: "avro", payload: marshal(func() ([]byte, error) { return avro.Marshal(avro.MustParse(eventAvroSchema), value) }), options: []functions.PayloadOption{functions.PayloadInfo(&event{}, functions.AVRO), functions.AvroSchema(eventAvroSchema)}},
+		{name: "msgpack", payload: marshal(func() ([]byte, error) { return msgpack.Marshal(value) }), options: []functions.PayloadOption{functions.PayloadInfo(&event{}, functions.MSGPACK)}},
+		{name: "cbor", payload: marshal(func() ([]byte, error) { return cbor.Marshal(value) }), options: []functions.PayloadOption{functions.PayloadInfo(&event{}, functions.CBOR)}},
+		{name: "xml", payload: xmlPayload, options: []functions.PayloadOption{functions.PayloadInfo(&event{}, functions.XML)}},
+		{name: "yaml", payload: marshal(func() ([]byte, error) { return yaml.Marshal(value) }), options: []functions.PayloadOption{functions.PayloadInfo(&event{}, functions.YAML)}},
+		{name: "text", payload: []byte("événement"), options: []functions.PayloadOption{functions.PayloadInfo(nil, functions.TEXT)}},
+		{name: "gob", payload: gobPayload.Bytes(), options: []functions.PayloadOption{functions.PayloadInfo(&event{}, functions.GOB)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				if _, raw := payload.([]byte); raw && tt.name != "bytes" {
+					t.Errorf("the handler got the raw payload, want it decoded")
+				}
+				return payload, headers, nil
+			}, tt.options...)
+			input := memphistest.NewEvent().AddMessage(tt.payload, nil).Build(t)
+
+			out, err := handler(context.Background(), input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(out.Messages) != 1 {
+				t.Fatalf("got %d emitted messages, failed: %+v", len(out.Messages), out.FailedMessages)
+			}
+			if emitted, _ := base64.StdEncoding.DecodeString(out.Messages[0].Payload); !bytes.Equal(emitted, tt.payload) {
+				t.Fatalf("emitted %q, want %q", emitted, tt.payload)
+			}
+		})
+	}
+}
+
+func TestJSONNumbersKeepLargeIntegers(t *testing.T) {
+	const payload = `{"id":7234234234234234234,"ids":[1234567890123456789]}`
+	handler := functions.BuildHandler(echo, functions.PayloadInfo(nil, functions.JSON), functions.WithJSONNumbers())
+	input := memphistest.NewEvent().AddMessage([]byte(payload), nil).Build(t)
+
+	out, err := handler(context.Background(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 1 {
+		t.Fatalf("got %d emitted messages, failed: %+v", len(out.Messages), out.FailedMessages)
+	}
+	if emitted, _ := base64.StdEncoding.DecodeString(out.Messages[0].Payload); string(emitted) != payload {
+		t.Fatalf("emitted %s, want %s", emitted, payload)
+	}
+}
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestJSONEncoderGolden(t *testing.T) {
+	type comment struct {
+		Author string  `json:"author"`
+		Body   string  `json:"body"`
+		Score  float64 `json:"score"`
+	}
+	value := comment{Author: "Zoë 🦊", Body: `<script>alert("a & b")</script>`, Score: 0.1}
+
+	tests := []struct {
+		name string
+		opts functions.JSONEncodeOptions
+	}{
+		{name: "default", opts: functions.JSONEncodeOptions{}},
+		{name: "escape_html", opts: functions.JSONEncodeOptions{EscapeHTML: true}},
+		{name: "indent", opts: functions.JSONEncodeOptions{Indent: "  "}},
+		{name: "trailing_newline", opts: functions.JSONEncodeOptions{TrailingNewline: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				return value, headers, nil
+			}, functions.PayloadInfo(nil, functions.JSON), functions.WithJSONEncoder(tt.opts))
+			input := memphistest.NewEvent().AddMessage([]byte(`{}`), nil).Build(t)
+
+			out, err := handler(context.Background(), input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(out.Messages) != 1 {
+				t.Fatalf("got %d emitted messages, failed: %+v", len(out.Messages), out.FailedMessages)
+			}
+			emitted, _ := base64.StdEncoding.DecodeString(out.Messages[0].Payload)
+
+			golden := filepath.Join("testdata", "json_encoder_"+tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, emitted, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(emitted, want) {
+				t.Fatalf("emitted\n%s\nwant\n%s", emitted, want)
+			}
+		})
+	}
+}
+
+func TestRouteRoundTrip(t *testing.T) {
+	router := functions.NewRouter()
+	router.Handle("type", "event", func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		e := payload.(*event)
+		e.Name = "updated " + e.Name
+		return e, headers, nil
+	}, functions.PayloadInfo(&event{}, functions.MSGPACK))
+	handler := functions.BuildHandler(router.Handler())
+	payload, err := msgpack.Marshal(event{ID: 1, Name: "event"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := memphistest.NewEvent().AddMessage(payload, map[string]string{"type": "event"}).Build(t)
+
+	out, err := handler(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 1 {
+		t.Fatalf("got %d emitted messages, failed: %+v", len(out.Messages), out.FailedMessages)
+	}
+	emitted, _ := base64.StdEncoding.DecodeString(out.Messages[0].Payload)
+	var got event
+	if err := msgpack.Unmarshal(emitted, &got); err != nil {
+		t.Fatalf("emitted payload isn't MessagePack: %v", err)
+	}
+	if want := (event{ID: 1, Name: "updated event"}); got != want {
+		t.Fatalf("emitted %+v, want %+v", got, want)
+	}
+}
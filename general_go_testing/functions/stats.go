@@ -0,0 +1,53 @@
+package functions
+
+import (
+	"time"
+)
+
+// Stats describes what an invocation did with the messages of its event, it is added to the output by WithStats.
+type Stats struct {
+	Processed        int           `json:"processed"`
+	Failed           int           `json:"failed"`
+	Unprocessed      int           `json:"unprocessed"`
+	Filtered         int           `json:"filtered"`
+	Duplicates       int           `json:"duplicates"`
+	StrippedHeaders  int           `json:"stripped_headers"`
+	TotalHandlerTime time.Duration `json:"total_handler_time_ns"`
+	MaxMessageTime   time.Duration `json:"max_message_time_ns"`
+}
+
+// WithStats adds the Stats of every invocation to its output.
+func WithStats() PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.Stats = true
+		return nil
+	}
+}
+
+// newStats summarizes the results of the messages of an event.
+// A message counts as processed when it emitted at least one message, and as filtered when it emitted none without failing.
+// Duplicates suppressed by WithDedupHeader are also counted as filtered or failed.
+func newStats(results []messageResult) *Stats {
+	var stats Stats
+	for _, result := range results {
+		switch result.outcome() {
+		case failedOutcome:
+			stats.Failed++
+		case unprocessedOutcome:
+			stats.Unprocessed++
+		case filteredOutcome:
+			stats.Filtered++
+		default:
+			stats.Processed++
+		}
+		if result.duplicate {
+			stats.Duplicates++
+		}
+		stats.StrippedHeaders += result.strippedHeaders
+
+		stats.TotalHandlerTime += result.handlerDuration
+		stats.MaxMessageTime = max(stats.MaxMessageTime, result.duration)
+	}
+
+	return &stats
+}
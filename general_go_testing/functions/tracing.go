@@ -0,0 +1,62 @@
+package functions
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "go_template/memphis-functions"
+
+// WithTracing creates a span for every message with tp, continuing the trace of the W3C traceparent header of the message if present.
+// The handler gets the span's context so its own spans become children of it.
+func WithTracing(tp trace.TracerProvider) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		payloadOptions.TracerProvider = tp
+		return nil
+	}
+}
+
+// startMessageSpan starts the span of msg, it returns a nil span when tracing isn't enabled.
+func (payloadOptions *PayloadOptions) startMessageSpan(ctx context.Context, msg MemphisMsg) (context.Context, trace.Span) {
+	if payloadOptions.TracerProvider == nil {
+		return ctx, nil
+	}
+
+	ctx = propagation.TraceContext{}.Extract(ctx, headerCarrier(msg.Headers))
+	return payloadOptions.TracerProvider.Tracer(tracerName).Start(ctx, "memphis.process_message", trace.WithSpanKind(trace.SpanKindConsumer))
+}
+
+// endMessageSpan records the outcome of the message on its span and ends it.
+func endMessageSpan(span trace.Span, result messageResult) {
+	if span == nil {
+		return
+	}
+
+	span.SetAttributes(attribute.String("memphis.message.outcome", result.outcome()))
+	if result.failedMessage != nil {
+		span.SetStatus(codes.Error, result.failedMessage.Error)
+	}
+	span.End()
+}
+
+// headerCarrier is a propagation.TextMapCarrier over the headers of a message, looking them up case-insensitively
+// so traceparent is found whatever casing the producer or the header normalization used.
+type headerCarrier Headers
+
+func (c headerCarrier) Get(key string) string {
+	return Headers(c).Get(key)
+}
+
+func (c headerCarrier) Set(key string, value string) {
+	Headers(c).Set(key, value)
+}
+
+func (c headerCarrier) Keys() []string {
+	return slices.Collect(maps.Keys(c))
+}
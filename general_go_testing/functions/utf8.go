@@ -0,0 +1,61 @@
+package functions
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// UTF8Validation is what WithUTF8Validation does with TEXT and JSON payloads that aren't valid UTF-8.
+type UTF8Validation int
+
+const (
+	// UTF8ValidationOff leaves the payloads as they are, it is the default.
+	UTF8ValidationOff UTF8Validation = iota
+	// RejectInvalidUTF8 fails the messages with invalid UTF-8, reporting the offset of the first invalid byte.
+	RejectInvalidUTF8
+	// ReplaceInvalidUTF8 replaces every run of invalid bytes with U+FFFD before the handler gets the payload.
+	ReplaceInvalidUTF8
+)
+
+// WithUTF8Validation checks that TEXT and JSON payloads are valid UTF-8 once decompressed and decrypted,
+// before the handler gets them, failing or repairing the messages that aren't as set by mode.
+// Messages rejected fail with the InvalidUTF8ErrorCategory.
+func WithUTF8Validation(mode UTF8Validation) PayloadOption {
+	return func(payloadOptions *PayloadOptions) error {
+		if mode < UTF8ValidationOff || mode > ReplaceInvalidUTF8 {
+			return fmt.Errorf("invalid UTF-8 validation mode %d", mode)
+		}
+		payloadOptions.UTF8Validation = mode
+		return nil
+	}
+}
+
+// validateUTF8 applies the UTF8Validation to the payload of a TEXT or JSON message.
+func (payloadOptions *PayloadOptions) validateUTF8(payload []byte) ([]byte, error) {
+	if payloadOptions.UTF8Validation == UTF8ValidationOff || (payloadOptions.PayloadType != TEXT && payloadOptions.PayloadType != JSON) {
+		return payload, nil
+	}
+
+	offset := invalidUTF8Offset(payload)
+	switch {
+	case offset < 0:
+		return payload, nil
+	case payloadOptions.UTF8Validation == ReplaceInvalidUTF8:
+		return bytes.ToValidUTF8(payload, []byte(string(utf8.RuneError))), nil
+	default:
+		return nil, fmt.Errorf("payload is not valid UTF-8, invalid byte at offset %d", offset)
+	}
+}
+
+// invalidUTF8Offset returns the offset of the first byte of payload that isn't valid UTF-8, or -1 if it is valid.
+func invalidUTF8Offset(payload []byte) int {
+	for offset := 0; offset < len(payload); {
+		r, size := utf8.DecodeRune(payload[offset:])
+		if r == utf8.RuneError && size == 1 {
+			return offset
+		}
+		offset += size
+	}
+	return -1
+}
@@ -0,0 +1,16 @@
+// Package gcf runs Memphis functions on Google Cloud Functions, which invoke functions over HTTP.
+// Build with the gcf tag, which leaves the Lambda runtime out of the functions package and enables Register.
+// It is a module of its own so the functions framework isn't a dependency of the functions running on Lambda.
+package gcf
+
+import (
+	"net/http"
+
+	"go_template/functions"
+)
+
+// Handler returns the http.Handler Register registers the function with, processing every request as an event
+// like functions.ServeHTTP does, e.g. to register it with another framework or to test it with httptest.
+func Handler(eventHandler functions.HandlerType, options ...functions.PayloadOption) http.Handler {
+	return functions.NewHTTPHandler(eventHandler, options...)
+}
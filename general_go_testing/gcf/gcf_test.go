@@ -0,0 +1,59 @@
+package gcf_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go_template/functions"
+	"go_template/gcf"
+)
+
+func TestHandler(t *testing.T) {
+	handler := gcf.Handler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		return []byte(strings.ToUpper(string(payload.([]byte)))), headers, nil
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	body := `{"inputs":{},"messages":[{"headers":{},"payload":"` + base64.StdEncoding.EncodeToString([]byte("payload")) + `"}]}`
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var output functions.MemphisOutput
+	if err := json.NewDecoder(resp.Body).Decode(&output); err != nil {
+		t.Fatal(err)
+	}
+	if len(output.Messages) != 1 {
+		t.Fatalf("got %d emitted messages, failed: %+v", len(output.Messages), output.FailedMessages)
+	}
+	if payload, _ := base64.StdEncoding.DecodeString(output.Messages[0].Payload); string(payload) != "PAYLOAD" {
+		t.Fatalf("emitted %q, want PAYLOAD", payload)
+	}
+}
+
+func TestHandlerInvalidOptions(t *testing.T) {
+	handler := gcf.Handler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		return payload, headers, nil
+	}, functions.WithMaxConcurrency(-1))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(`{"inputs":{},"messages":[]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+}
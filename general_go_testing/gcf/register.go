@@ -0,0 +1,20 @@
+//go:build gcf
+
+package gcf
+
+import (
+	framework "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+
+	"go_template/functions"
+)
+
+// Register registers the function as the HTTP function name of the functions framework, from an init function:
+//
+//	func init() {
+//		gcf.Register("memphis", handler, functions.PayloadInfo(&data, functions.JSON))
+//	}
+//
+// The options are applied once, invalid ones fail every invocation like they do with functions.CreateFunction.
+func Register(name string, eventHandler functions.HandlerType, options ...functions.PayloadOption) {
+	framework.HTTP(name, Handler(eventHandler, options...).ServeHTTP)
+}
@@ -0,0 +1,30 @@
+// Package httpjson holds the JSON request and response helpers shared by the HTTP handlers of the functions,
+// such as functions.ServeHTTP and the Azure Functions custom handler.
+package httpjson
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// DecodeRequestBody decodes the JSON body of r into v, reading at most maxBodySize bytes,
+// or returns the status to respond with when it can't.
+func DecodeRequestBody(w http.ResponseWriter, r *http.Request, maxBodySize int64, v any) (int, error) {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(v); err != nil {
+		if maxBytesErr := (*http.MaxBytesError)(nil); errors.As(err, &maxBytesErr) {
+			return http.StatusRequestEntityTooLarge, err
+		}
+		return http.StatusBadRequest, err
+	}
+	return http.StatusOK, nil
+}
+
+// WriteJSON responds with status and body encoded as JSON, without escaping HTML.
+func WriteJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(body) // the status is already sent, the client sees the truncated body
+}
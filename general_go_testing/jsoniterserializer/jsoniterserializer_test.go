@@ -0,0 +1,63 @@
+package jsoniterserializer_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go_template/functions"
+	"go_template/jsoniterserializer"
+	"go_template/memphistest"
+)
+
+type order struct {
+	ID       int64             `json:"id"`
+	Customer string            `json:"customer"`
+	Total    float64           `json:"total"`
+	Tags     []string          `json:"tags"`
+	Lines    []orderLine       `json:"lines"`
+	Meta     map[string]string `json:"meta"`
+}
+
+type orderLine struct {
+	SKU      string  `json:"sku"`
+	Quantity int     `json:"quantity"`
+	Price    float64 `json:"price"`
+	Note     string  `json:"note"`
+}
+
+// representativeOrder returns an order marshaling to about 4KB of JSON.
+func representativeOrder() order {
+	o := order{ID: 7234234234234234, Customer: "customer-42", Total: 1234.5, Tags: []string{"priority", "gift"}, Meta: map[string]string{"channel": "web", "region": "eu-west-1"}}
+	for i := range 52 {
+		o.Lines = append(o.Lines, orderLine{SKU: "SKU-" + strings.Repeat("X", 8), Quantity: i + 1, Price: 9.99, Note: "handle with care"})
+	}
+	return o
+}
+
+func BenchmarkSerializers(b *testing.B) {
+	benchmarks := []struct {
+		name    string
+		options []functions.PayloadOption
+	}{
+		{name: "encoding/json"},
+		{name: "jsoniter", options: []functions.PayloadOption{functions.WithSerializer(jsoniterserializer.New())}},
+		{name: "jsoniter fastest", options: []functions.PayloadOption{functions.WithSerializer(jsoniterserializer.NewFastest())}},
+	}
+	event := memphistest.NewEvent().AddJSONMessage(representativeOrder(), nil).Build(b)
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			options := append([]functions.PayloadOption{functions.PayloadInfo(&order{}, functions.JSON), functions.WithPerMessageLogging(false)}, bm.options...)
+			handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+				return payload, headers, nil
+			}, options...)
+			b.ReportAllocs()
+			for b.Loop() {
+				out, err := handler(context.Background(), event)
+				if err != nil || len(out.Messages) != 1 {
+					b.Fatalf("invocation failed: %v, %+v", err, out)
+				}
+			}
+		})
+	}
+}
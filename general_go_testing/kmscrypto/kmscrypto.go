@@ -0,0 +1,117 @@
+// Package kmscrypto is a reference envelope encryption implementation of the Crypto interface of Memphis functions,
+// payloads are encrypted with AES-256-GCM under a data key generated and wrapped by KMS.
+package kmscrypto
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"go_template/functions"
+)
+
+// Headers carrying the encryption metadata of a message.
+const (
+	KeyIDHeader   = "x-encryption-key-id"
+	DataKeyHeader = "x-encryption-data-key"
+	IVHeader      = "x-encryption-iv"
+)
+
+// KMS is the subset of a KMS client needed for envelope encryption,
+// a thin wrapper around the AWS SDK KMS client GenerateDataKey and Decrypt calls satisfies it.
+type KMS interface {
+	// GenerateDataKey returns a new 256 bit data key in plaintext and encrypted under keyID.
+	GenerateDataKey(ctx context.Context, keyID string) (plaintext []byte, ciphertext []byte, err error)
+	// Decrypt returns the plaintext of a data key encrypted by GenerateDataKey.
+	Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error)
+}
+
+// Envelope encrypts the outputs with a fresh data key for every message, and decrypts incoming payloads with the data key in their headers.
+type Envelope struct {
+	kms   KMS
+	keyID string
+}
+
+// New returns an Envelope encrypting data keys under the KMS key keyID.
+func New(kms KMS, keyID string) *Envelope {
+	return &Envelope{kms: kms, keyID: keyID}
+}
+
+// Decrypt decrypts a payload encrypted by Encrypt using the encryption metadata in headers, whose keys are matched case-insensitively.
+func (e *Envelope) Decrypt(ctx context.Context, payload []byte, headers map[string]string) ([]byte, error) {
+	if !functions.Headers(headers).Has(KeyIDHeader) {
+		return nil, errors.New("missing header " + KeyIDHeader)
+	}
+	encryptedDataKey, err := decodeHeader(headers, DataKeyHeader)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := decodeHeader(headers, IVHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := e.kms.Decrypt(ctx, functions.Headers(headers).Get(KeyIDHeader), encryptedDataKey)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decrypt data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid iv length %d", len(iv))
+	}
+
+	return aead.Open(nil, iv, payload, nil)
+}
+
+// Encrypt encrypts payload under a new data key, and returns a copy of headers with the encryption metadata set.
+func (e *Envelope) Encrypt(ctx context.Context, payload []byte, headers map[string]string) ([]byte, map[string]string, error) {
+	dataKey, encryptedDataKey, err := e.kms.GenerateDataKey(ctx, e.keyID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't generate data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	iv := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, err
+	}
+
+	encryptedHeaders := make(map[string]string, len(headers)+3)
+	for k, v := range headers {
+		encryptedHeaders[k] = v
+	}
+	encryptedHeaders[KeyIDHeader] = e.keyID
+	encryptedHeaders[DataKeyHeader] = base64.StdEncoding.EncodeToString(encryptedDataKey)
+	encryptedHeaders[IVHeader] = base64.StdEncoding.EncodeToString(iv)
+
+	return aead.Seal(nil, iv, payload, nil), encryptedHeaders, nil
+}
+
+func newAEAD(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func decodeHeader(headers map[string]string, key string) ([]byte, error) {
+	value := functions.Headers(headers).Get(key)
+	if !functions.Headers(headers).Has(key) {
+		return nil, errors.New("missing header " + key)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid header %s: %w", key, err)
+	}
+	return decoded, nil
+}
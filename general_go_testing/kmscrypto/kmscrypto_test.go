@@ -0,0 +1,52 @@
+package kmscrypto_test
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"go_template/functions"
+	"go_template/kmscrypto"
+	"go_template/memphistest"
+)
+
+// fakeKMS "wraps" data keys by returning them as is.
+type fakeKMS struct{}
+
+func (fakeKMS) GenerateDataKey(ctx context.Context, keyID string) ([]byte, []byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	return dataKey, dataKey, nil
+}
+
+func (fakeKMS) Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	return ciphertext, nil
+}
+
+func TestDecryptWithHeaderNormalization(t *testing.T) {
+	envelope := kmscrypto.New(fakeKMS{}, "key")
+	encrypted, headers, err := envelope.Encrypt(context.Background(), []byte("payload"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got any
+	handler := functions.BuildHandler(func(payload any, headers map[string]string, inputs map[string]string) (any, map[string]string, error) {
+		got = payload
+		return payload, headers, nil
+	}, functions.WithPayloadCrypto(envelope), functions.WithHeaderNormalization(functions.CanonicalMIMEHeaders))
+	event := memphistest.NewEvent().AddMessage(encrypted, headers).Build(t)
+
+	out, err := handler(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 1 {
+		t.Fatalf("got %d emitted messages, failed: %+v", len(out.Messages), out.FailedMessages)
+	}
+	if payload, ok := got.([]byte); !ok || string(payload) != "payload" {
+		t.Fatalf("handler got %#v, want the decrypted payload", got)
+	}
+}
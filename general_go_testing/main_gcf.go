@@ -0,0 +1,22 @@
+//go:build gcf
+
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+
+	"go_template/functions"
+)
+
+// main serves the function over HTTP on the port Google Cloud Functions sets in PORT, as the Lambda runtime isn't built in.
+// Functions deployed with the functions framework register their handler with the gcf module instead.
+func main() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	var data Data
+	log.Fatal(functions.ServeHTTP(net.JoinHostPort("", port), ObjectHandler, functions.PayloadInfo(&data, functions.JSON)))
+}
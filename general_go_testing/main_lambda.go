@@ -0,0 +1,15 @@
+//go:build !gcf
+
+package main
+
+import (
+	"go_template/functions"
+)
+
+func main() {
+	var data Data
+	functions.CreateFunction(ObjectHandler, functions.PayloadInfo(&data, functions.JSON))
+	// functions.CreateFunction(BytesHandler)
+	// functions.CreateFunction(ProtoHandler, functions.PayloadInfo(&user_message.Message{}, functions.PROTOBUF))
+	// functions.CreateTypedFunction(TypedObjectHandler)
+}
@@ -0,0 +1,118 @@
+// Package memphistest builds events and checks the output of a handler returned by functions.BuildHandler,
+// so tests of Memphis functions exercise the full decode, handler and encode path.
+package memphistest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"go_template/functions"
+)
+
+// TestingT is the part of testing.TB the helpers need.
+type TestingT interface {
+	Helper()
+	Fatalf(format string, args ...any)
+}
+
+// EventBuilder builds a MemphisEvent for tests.
+type EventBuilder struct {
+	event functions.MemphisEvent
+	err   error
+}
+
+// NewEvent starts building an event without inputs and messages.
+func NewEvent() *EventBuilder {
+	return &EventBuilder{
+		event: functions.MemphisEvent{Inputs: map[string]string{}},
+	}
+}
+
+// AddInput adds an input to the event.
+func (b *EventBuilder) AddInput(name string, value string) *EventBuilder {
+	b.event.Inputs[name] = value
+	return b
+}
+
+// AddMessage adds a message with a raw payload, base64 encoded as in real events.
+func (b *EventBuilder) AddMessage(payload []byte, headers map[string]string) *EventBuilder {
+	b.event.Messages = append(b.event.Messages, functions.MemphisMsg{
+		Headers: headers,
+		Payload: base64.StdEncoding.EncodeToString(payload),
+	})
+	return b
+}
+
+// AddJSONMessage adds a message whose payload is v marshaled as JSON.
+func (b *EventBuilder) AddJSONMessage(v any, headers map[string]string) *EventBuilder {
+	payload, err := json.Marshal(v)
+	if err != nil && b.err == nil {
+		b.err = err
+	}
+	return b.AddMessage(payload, headers)
+}
+
+// Build returns the event, failing the test if a message couldn't be marshaled.
+func (b *EventBuilder) Build(t TestingT) *functions.MemphisEvent {
+	t.Helper()
+	if b.err != nil {
+		t.Fatalf("couldn't build event: %v", b.err)
+	}
+	event := b.event
+	return &event
+}
+
+// RequireEmitted checks that the output message at idx has a JSON payload equal to want, which must be a pointer.
+func RequireEmitted(t TestingT, out *functions.MemphisOutput, idx int, want any) {
+	t.Helper()
+	if out == nil || idx >= len(out.Messages) {
+		emitted := 0
+		if out != nil {
+			emitted = len(out.Messages)
+		}
+		t.Fatalf("expected an emitted message at index %d, got %d emitted messages", idx, emitted)
+		return
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(out.Messages[idx].Payload)
+	if err != nil {
+		t.Fatalf("emitted message %d isn't base64 encoded: %v", idx, err)
+		return
+	}
+
+	got := reflect.New(reflect.TypeOf(want).Elem()).Interface()
+	if err := json.Unmarshal(payload, got); err != nil {
+		t.Fatalf("couldn't unmarshal emitted message %d: %v, payload: %s", idx, err, payload)
+		return
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("emitted message %d = %+v, want %+v", idx, reflect.ValueOf(got).Elem(), reflect.ValueOf(want).Elem())
+	}
+}
+
+// RequireFailed checks that the failed message at idx has an error containing substr.
+func RequireFailed(t TestingT, out *functions.MemphisOutput, idx int, substr string) {
+	t.Helper()
+	if out == nil || idx >= len(out.FailedMessages) {
+		failed := 0
+		if out != nil {
+			failed = len(out.FailedMessages)
+		}
+		t.Fatalf("expected a failed message at index %d, got %d failed messages", idx, failed)
+		return
+	}
+
+	if errMsg := out.FailedMessages[idx].Error; !strings.Contains(errMsg, substr) {
+		t.Fatalf("failed message %d has error %q, want it to contain %q", idx, errMsg, substr)
+	}
+}
+
+// ContextWithResources returns ctx with the resources handlers get with functions.Resource,
+// to call a functions.HandlerWithContextType directly with fakes. Use functions.WithResource to inject them through BuildHandler.
+func ContextWithResources(ctx context.Context, resources map[string]any) context.Context {
+	return functions.ContextWithResources(ctx, resources)
+}
@@ -0,0 +1,72 @@
+// Package promrecorder implements the MetricsRecorder interface of Memphis functions with Prometheus metrics,
+// for WithMetricsRecorder to expose the outcome of the messages to a Prometheus scrape.
+//
+// The Prometheus client counters and histograms satisfy its interfaces as they are:
+//
+//	recorder := promrecorder.New(
+//		promauto.NewCounter(prometheus.CounterOpts{Name: promrecorder.ProcessedName, Help: promrecorder.ProcessedHelp}),
+//		promauto.NewCounter(prometheus.CounterOpts{Name: promrecorder.FailedName, Help: promrecorder.FailedHelp}),
+//		promauto.NewCounter(prometheus.CounterOpts{Name: promrecorder.FilteredName, Help: promrecorder.FilteredHelp}),
+//		promauto.NewHistogram(prometheus.HistogramOpts{Name: promrecorder.HandlerDurationName, Help: promrecorder.HandlerDurationHelp}),
+//	)
+package promrecorder
+
+import (
+	"time"
+)
+
+// Names and help texts of the metrics, for registering them.
+const (
+	ProcessedName       = "memphis_function_messages_processed_total"
+	ProcessedHelp       = "Messages that emitted at least one message."
+	FailedName          = "memphis_function_messages_failed_total"
+	FailedHelp          = "Messages that failed."
+	FilteredName        = "memphis_function_messages_filtered_total"
+	FilteredHelp        = "Messages that emitted none without failing."
+	HandlerDurationName = "memphis_function_handler_duration_seconds"
+	HandlerDurationHelp = "Time the handler took for a message, in seconds."
+)
+
+// Counter is the subset of a Prometheus counter needed to count messages, a prometheus.Counter satisfies it.
+type Counter interface {
+	Add(float64)
+}
+
+// Observer is the subset of a Prometheus histogram needed to record durations, a prometheus.Histogram satisfies it.
+type Observer interface {
+	Observe(float64)
+}
+
+// Recorder records the outcome of the messages with Prometheus metrics,
+// it is safe for concurrent use as long as its metrics are, which the Prometheus ones are.
+type Recorder struct {
+	processed       Counter
+	failed          Counter
+	filtered        Counter
+	handlerDuration Observer
+}
+
+// New returns a Recorder counting the messages with processed, failed and filtered and recording the handler durations in seconds with handlerDuration.
+func New(processed, failed, filtered Counter, handlerDuration Observer) *Recorder {
+	return &Recorder{processed: processed, failed: failed, filtered: filtered, handlerDuration: handlerDuration}
+}
+
+// IncProcessed counts n messages that emitted at least one message.
+func (r *Recorder) IncProcessed(n int) {
+	r.processed.Add(float64(n))
+}
+
+// IncFailed counts n messages that failed.
+func (r *Recorder) IncFailed(n int) {
+	r.failed.Add(float64(n))
+}
+
+// IncFiltered counts n messages that emitted none without failing.
+func (r *Recorder) IncFiltered(n int) {
+	r.filtered.Add(float64(n))
+}
+
+// ObserveHandlerDuration records how long the handler took for a message.
+func (r *Recorder) ObserveHandlerDuration(d time.Duration) {
+	r.handlerDuration.Observe(d.Seconds())
+}
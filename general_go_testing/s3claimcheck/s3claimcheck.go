@@ -0,0 +1,46 @@
+// Package s3claimcheck is an S3 backed implementation of the PayloadFetcher interface of Memphis functions,
+// used by WithClaimCheck to fetch and store the payloads of claim-check messages.
+package s3claimcheck
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// S3 is the subset of an S3 client needed for claim checks,
+// a thin wrapper around the AWS SDK S3 client GetObject and PutObject calls satisfies it.
+type S3 interface {
+	GetObject(ctx context.Context, bucket string, key string) ([]byte, error)
+	PutObject(ctx context.Context, bucket string, key string, body []byte) error
+}
+
+// Fetcher fetches payloads from any bucket, and stores them in its own bucket under its prefix.
+type Fetcher struct {
+	s3     S3
+	bucket string
+	prefix string
+}
+
+// New returns a Fetcher storing payloads in bucket, under keys starting with prefix.
+func New(s3 S3, bucket string, prefix string) *Fetcher {
+	return &Fetcher{s3: s3, bucket: bucket, prefix: prefix}
+}
+
+// Fetch returns the payload stored under key in bucket.
+func (f *Fetcher) Fetch(ctx context.Context, bucket string, key string) ([]byte, error) {
+	return f.s3.GetObject(ctx, bucket, key)
+}
+
+// Store stores payload under a new random key.
+func (f *Fetcher) Store(ctx context.Context, payload []byte) (string, string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	key := f.prefix + hex.EncodeToString(id)
+	if err := f.s3.PutObject(ctx, f.bucket, key, payload); err != nil {
+		return "", "", err
+	}
+	return f.bucket, key, nil
+}